@@ -0,0 +1,148 @@
+package lru
+
+import "github.com/QuarkChain/golang-lru/simplelru"
+
+// ChainedCache layers a primary cache in front of a secondary one, e.g. a
+// small count-limited Cache in front of a large byte-limited
+// AccountingCache. Misses in the primary consult the secondary, and hits
+// there are promoted back into the primary.
+//
+// A chain built by Chain leaves both levels' eviction callbacks alone. One
+// built by NewChainWithDemotion owns its primary, and demotes the entries
+// it evicts into the secondary:
+//
+//	l2, _ := NewWithAccounting(64<<20, onAccount, nil)
+//	chain, _ := NewChainWithDemotion(1024, onEvicted, l2)
+//
+// A ChainedCache takes no lock of its own: it is as thread-safe as its two
+// levels, and concurrent operations on the same key may race between them.
+type ChainedCache struct {
+	primary   simplelru.LRUCache
+	secondary simplelru.LRUCache
+
+	// demote is set by NewChainWithDemotion, whose primary is a *Cache that
+	// reports its evictions to demoteEvicted. onEvicted is then called for
+	// entries leaving the primary without being demoted.
+	demote    bool
+	onEvicted func(key, value interface{})
+}
+
+// taker is implemented by caches that can hand an entry back without firing
+// their eviction callback.
+type taker interface {
+	Take(key interface{}) (value interface{}, ok bool)
+}
+
+// Chain constructs a ChainedCache from the two levels. Entries evicted from
+// the primary are reported to its own eviction callback, not demoted.
+func Chain(primary, secondary simplelru.LRUCache) *ChainedCache {
+	return &ChainedCache{
+		primary:   primary,
+		secondary: secondary,
+	}
+}
+
+// NewChainWithDemotion constructs a ChainedCache whose primary is a Cache of
+// the given size, in front of secondary. Entries the primary evicts to make
+// room are added to the secondary. onEvicted, if not nil, is called for
+// entries that leave the primary through Remove or Purge instead.
+func NewChainWithDemotion(size int, onEvicted func(key, value interface{}), secondary simplelru.LRUCache) (*ChainedCache, error) {
+	c := &ChainedCache{
+		secondary: secondary,
+		demote:    true,
+		onEvicted: onEvicted,
+	}
+	primary, err := NewWithEvict(size, c.demoteEvicted)
+	if err != nil {
+		return nil, err
+	}
+	c.primary = primary
+	return c, nil
+}
+
+// take removes key from l without firing the eviction callback when l
+// supports it.
+func take(l simplelru.LRUCache, key interface{}) (value interface{}, ok bool) {
+	if t, isTaker := l.(taker); isTaker {
+		return t.Take(key)
+	}
+	if value, ok = l.Peek(key); ok {
+		l.Remove(key)
+	}
+	return value, ok
+}
+
+// demoteEvicted is the eviction callback of a demoting chain's primary.
+func (c *ChainedCache) demoteEvicted(key, value interface{}) {
+	c.secondary.Add(key, value)
+}
+
+// removePrimary removes key from the primary, bypassing demotion.
+func (c *ChainedCache) removePrimary(key interface{}) (present bool) {
+	if !c.demote {
+		return c.primary.Remove(key)
+	}
+	value, present := take(c.primary, key)
+	if present && c.onEvicted != nil {
+		c.onEvicted(key, value)
+	}
+	return present
+}
+
+// Add adds a value to the primary, removing any older copy from the
+// secondary, which reports it to the secondary's eviction callback. Returns
+// true if the primary evicted an entry.
+func (c *ChainedCache) Add(key, value interface{}) (evicted bool) {
+	c.secondary.Remove(key)
+	return c.primary.Add(key, value)
+}
+
+// Get looks up a key's value in the primary, then in the secondary. An
+// entry found in the secondary is moved into the primary.
+func (c *ChainedCache) Get(key interface{}) (value interface{}, ok bool) {
+	if value, ok = c.primary.Get(key); ok {
+		return value, true
+	}
+	if value, ok = take(c.secondary, key); ok {
+		c.primary.Add(key, value)
+	}
+	return value, ok
+}
+
+// Peek returns the key value from either level, without updating the
+// "recently used"-ness of the key or promoting it.
+func (c *ChainedCache) Peek(key interface{}) (value interface{}, ok bool) {
+	if value, ok = c.primary.Peek(key); ok {
+		return value, true
+	}
+	return c.secondary.Peek(key)
+}
+
+// Contains checks if a key is in either level, without updating the
+// recent-ness or promoting it.
+func (c *ChainedCache) Contains(key interface{}) bool {
+	return c.primary.Contains(key) || c.secondary.Contains(key)
+}
+
+// Remove removes the provided key from both levels, firing their eviction
+// callbacks. Removing it from the primary does not demote it.
+func (c *ChainedCache) Remove(key interface{}) (present bool) {
+	present = c.removePrimary(key)
+	if c.secondary.Remove(key) {
+		present = true
+	}
+	return present
+}
+
+// Purge is used to completely clear both levels. Entries purged from the
+// primary are not demoted.
+func (c *ChainedCache) Purge() {
+	if c.demote {
+		for _, key := range c.primary.Keys() {
+			c.removePrimary(key)
+		}
+	} else {
+		c.primary.Purge()
+	}
+	c.secondary.Purge()
+}
@@ -0,0 +1,119 @@
+package lru
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var released, dropped []interface{}
+	l2, err := NewWithAccounting(6, accountBytes, func(k, v interface{}) {
+		dropped = append(dropped, k)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	chain, err := NewChainWithDemotion(2, func(k, v interface{}) {
+		released = append(released, k)
+	}, l2)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l1 := chain.primary
+
+	for i := 0; i < 4; i++ {
+		chain.Add(fmt.Sprint(i), []byte(fmt.Sprint(i)))
+	}
+	// 0 and 1 were demoted, which does not count as leaving the primary.
+	if l1.Len() != 2 || l2.Len() != 2 || !l2.Contains("0") || !l2.Contains("1") {
+		t.Fatalf("bad levels: %v %v", l1.Keys(), l2.Keys())
+	}
+	if len(released) != 0 {
+		t.Fatalf("demotion should not fire the callback: %v", released)
+	}
+
+	// A secondary hit is promoted, demoting the primary's oldest.
+	if v, ok := chain.Get("0"); !ok || string(v.([]byte)) != "0" {
+		t.Fatalf("bad value: %v, %v", v, ok)
+	}
+	if !l1.Contains("0") || l2.Contains("0") || !l2.Contains("2") {
+		t.Fatalf("bad levels: %v %v", l1.Keys(), l2.Keys())
+	}
+	if len(dropped) != 0 {
+		t.Fatalf("promotion should not fire the secondary's callback: %v", dropped)
+	}
+
+	// Peek neither promotes nor demotes.
+	if v, ok := chain.Peek("1"); !ok || string(v.([]byte)) != "1" {
+		t.Fatalf("bad value: %v, %v", v, ok)
+	}
+	if l1.Contains("1") {
+		t.Fatalf("Peek should not promote")
+	}
+
+	// Re-adding a demoted key removes the stale copy, and the secondary's
+	// callback is told about it.
+	chain.Add("1", []byte("x"))
+	if l2.Contains("1") {
+		t.Fatalf("stale copy should be gone")
+	}
+	if len(dropped) != 1 || dropped[0] != "1" {
+		t.Fatalf("bad secondary evictions: %v", dropped)
+	}
+
+	// Removing from the primary fires the callback instead of demoting.
+	if !chain.Remove("1") || chain.Contains("1") {
+		t.Fatalf("1 should be removed")
+	}
+	if l2.Contains("1") {
+		t.Fatalf("Remove should not demote")
+	}
+	if len(released) != 1 || released[0] != "1" {
+		t.Fatalf("bad primary evictions: %v", released)
+	}
+	if _, ok := chain.Get("missing"); ok {
+		t.Fatalf("should miss")
+	}
+
+	// So does purging, which must not push entries into the secondary.
+	chain.Purge()
+	if l1.Len() != 0 || l2.Len() != 0 {
+		t.Fatalf("bad levels: %v %v", l1.Keys(), l2.Keys())
+	}
+	if len(released) != 2 || released[1] != "0" {
+		t.Fatalf("bad primary evictions: %v", released)
+	}
+	if len(dropped) != 3 {
+		t.Fatalf("bad secondary evictions: %v", dropped)
+	}
+}
+
+func TestChain_NoDemotion(t *testing.T) {
+	var released []interface{}
+	l1, err := NewWithEvict(2, func(k, v interface{}) {
+		released = append(released, k)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l2, err := New(4)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	chain := Chain(l1, l2)
+
+	chain.Add("a", 1)
+	chain.Add("b", 2)
+	chain.Add("c", 3)
+	if l2.Len() != 0 || len(released) != 1 || released[0] != "a" {
+		t.Fatalf("evictions should not be demoted: %v %v", l2.Keys(), released)
+	}
+
+	if !chain.Remove("b") || len(released) != 2 || released[1] != "b" {
+		t.Fatalf("Remove should fire the primary's callback: %v", released)
+	}
+	chain.Purge()
+	if len(released) != 3 || released[2] != "c" {
+		t.Fatalf("Purge should fire the primary's callback: %v", released)
+	}
+}
@@ -51,6 +51,32 @@ func (c *Cache) onEvicted(k, v interface{}) {
 	c.evictedVals = append(c.evictedVals, v)
 }
 
+// SetClock sets the clock used to timestamp audit records. A nil clock
+// restores the system clock.
+func (c *Cache) SetClock(clock simplelru.Clock) {
+	c.lock.Lock()
+	c.lru.SetClock(clock)
+	c.lock.Unlock()
+}
+
+// SetAuditLog keeps the last capacity administrative operations, such as
+// Resize, Purge and policy changes, with their before/after sizes. A
+// capacity of 0 disables the log.
+func (c *Cache) SetAuditLog(capacity int) {
+	c.lock.Lock()
+	c.lru.SetAuditLog(capacity)
+	c.lock.Unlock()
+}
+
+// AuditLog returns the recorded administrative operations, from oldest to
+// newest.
+func (c *Cache) AuditLog() []simplelru.AuditRecord {
+	c.lock.RLock()
+	records := c.lru.AuditLog()
+	c.lock.RUnlock()
+	return records
+}
+
 // Purge is used to completely clear the cache.
 func (c *Cache) Purge() {
 	var ks, vs []interface{}
@@ -74,12 +100,14 @@ func (c *Cache) Add(key, value interface{}) (evicted bool) {
 	var k, v interface{}
 	c.lock.Lock()
 	evicted = c.lru.Add(key, value)
-	if c.onEvictedCB != nil && evicted {
+	// Re-adding a tombstoned key reaps it without evicting anything.
+	reaped := c.onEvictedCB != nil && len(c.evictedKeys) > 0
+	if reaped {
 		k, v = c.evictedKeys[0], c.evictedVals[0]
 		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
 	}
 	c.lock.Unlock()
-	if c.onEvictedCB != nil && evicted {
+	if reaped {
 		c.onEvictedCB(k, v)
 	}
 	return
@@ -122,12 +150,13 @@ func (c *Cache) ContainsOrAdd(key, value interface{}) (ok, evicted bool) {
 		return true, false
 	}
 	evicted = c.lru.Add(key, value)
-	if c.onEvictedCB != nil && evicted {
+	reaped := c.onEvictedCB != nil && len(c.evictedKeys) > 0
+	if reaped {
 		k, v = c.evictedKeys[0], c.evictedVals[0]
 		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
 	}
 	c.lock.Unlock()
-	if c.onEvictedCB != nil && evicted {
+	if reaped {
 		c.onEvictedCB(k, v)
 	}
 	return false, evicted
@@ -145,12 +174,13 @@ func (c *Cache) PeekOrAdd(key, value interface{}) (previous interface{}, ok, evi
 		return previous, true, false
 	}
 	evicted = c.lru.Add(key, value)
-	if c.onEvictedCB != nil && evicted {
+	reaped := c.onEvictedCB != nil && len(c.evictedKeys) > 0
+	if reaped {
 		k, v = c.evictedKeys[0], c.evictedVals[0]
 		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
 	}
 	c.lock.Unlock()
-	if c.onEvictedCB != nil && evicted {
+	if reaped {
 		c.onEvictedCB(k, v)
 	}
 	return nil, false, evicted
@@ -161,17 +191,62 @@ func (c *Cache) Remove(key interface{}) (present bool) {
 	var k, v interface{}
 	c.lock.Lock()
 	present = c.lru.Remove(key)
-	if c.onEvictedCB != nil && present {
+	removed := c.onEvictedCB != nil && len(c.evictedKeys) > 0
+	if removed {
 		k, v = c.evictedKeys[0], c.evictedVals[0]
 		c.evictedKeys, c.evictedVals = c.evictedKeys[:0], c.evictedVals[:0]
 	}
 	c.lock.Unlock()
-	if c.onEvictedCB != nil && present {
-		c.onEvicted(k, v)
+	if removed {
+		c.onEvictedCB(k, v)
 	}
 	return
 }
 
+// SetDeferredRemoval controls whether Remove defers the eviction callback
+// until the next call to Reap.
+func (c *Cache) SetDeferredRemoval(enabled bool) {
+	c.lock.Lock()
+	c.lru.SetDeferredRemoval(enabled)
+	c.lock.Unlock()
+}
+
+// Reap invokes the eviction callback for every entry removed since the last
+// call, outside of the critical section. Returns the number reaped.
+func (c *Cache) Reap() (reaped int) {
+	var ks, vs []interface{}
+	c.lock.Lock()
+	reaped = c.lru.Reap()
+	if c.onEvictedCB != nil && reaped > 0 {
+		ks, vs = c.evictedKeys, c.evictedVals
+		c.initEvictBuffers()
+	}
+	c.lock.Unlock()
+	if c.onEvictedCB != nil && reaped > 0 {
+		for i := 0; i < len(ks); i++ {
+			c.onEvictedCB(ks[i], vs[i])
+		}
+	}
+	return reaped
+}
+
+// Tombstones returns the number of removed entries waiting for Reap.
+func (c *Cache) Tombstones() int {
+	c.lock.RLock()
+	n := c.lru.Tombstones()
+	c.lock.RUnlock()
+	return n
+}
+
+// Take removes the provided key from the cache and returns its value,
+// without invoking the eviction callback.
+func (c *Cache) Take(key interface{}) (value interface{}, ok bool) {
+	c.lock.Lock()
+	value, ok = c.lru.Take(key)
+	c.lock.Unlock()
+	return value, ok
+}
+
 // Resize changes the cache size.
 func (c *Cache) Resize(size int) (evicted int) {
 	var ks, vs []interface{}
@@ -222,6 +297,25 @@ func (c *Cache) Keys() []interface{} {
 	return keys
 }
 
+// KeysWhere returns the keys of the entries for which pred returns true,
+// from oldest to newest. pred is evaluated under the lock in a single pass
+// and must not call back into the cache.
+func (c *Cache) KeysWhere(pred func(key, value interface{}) bool) []interface{} {
+	c.lock.RLock()
+	keys := c.lru.KeysWhere(pred)
+	c.lock.RUnlock()
+	return keys
+}
+
+// Position returns the distance of key from eviction, 0 being the next
+// entry to be evicted and Len()-1 the most recently used one.
+func (c *Cache) Position(key interface{}) (pos int, ok bool) {
+	c.lock.RLock()
+	pos, ok = c.lru.Position(key)
+	c.lock.RUnlock()
+	return pos, ok
+}
+
 // Len returns the number of items in the cache.
 func (c *Cache) Len() int {
 	c.lock.RLock()
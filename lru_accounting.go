@@ -0,0 +1,560 @@
+package lru
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/QuarkChain/golang-lru/simplelru"
+)
+
+// AccountingCache is a thread-safe LRU cache bounded by the total size
+// reported by an accounting callback rather than by the number of entries.
+type AccountingCache struct {
+	lru                      *simplelru.LRUWithAccounting
+	evictedKeys, evictedVals []interface{}
+	onEvictedCB              func(k, v interface{})
+	lock                     sync.RWMutex
+
+	// Background eviction state, only set up by
+	// NewWithAccountingBackgroundEvict. evictCh is nil when eviction
+	// happens inline. lowWatermark is the configured watermark clamped to
+	// the current limit.
+	watermark    int
+	lowWatermark int
+	signalledAt  time.Time
+	evictCh      chan struct{}
+	closeCh      chan struct{}
+	doneCh       chan struct{}
+}
+
+// backgroundEvictBatch is the number of entries the background evictor
+// evicts per pass before letting other callers take the lock.
+const backgroundEvictBatch = 64
+
+// importBatch is the number of entries ImportOrdered adds per pass, after
+// reading them without the lock.
+const importBatch = 64
+
+// evictorLagThreshold is how late the background evictor may start trimming
+// before EventEvictorLag is logged.
+const evictorLagThreshold = 100 * time.Millisecond
+
+// NewWithAccounting constructs a cache whose accounting size, as measured by
+// onAccount, never exceeds limit. Oldest entries are evicted inline by Add.
+func NewWithAccounting(limit int, onAccount simplelru.AccountCallback, onEvicted func(key, value interface{})) (c *AccountingCache, err error) {
+	c = &AccountingCache{
+		onEvictedCB: onEvicted,
+	}
+	if onEvicted != nil {
+		c.initEvictBuffers()
+		onEvicted = c.onEvicted
+	}
+	c.lru, err = simplelru.NewLRUWithAccounting(limit, onAccount, onEvicted)
+	return
+}
+
+// NewWithAccountingBackgroundEvict constructs an accounting cache that
+// evicts in a background goroutine. Add never evicts: once the accounting
+// size goes over limit it only signals the goroutine, which then evicts the
+// oldest entries until the size is at most lowWatermark, in short batches
+// that release the lock in between so that Add is never held up by a long
+// eviction chain. The cache may therefore temporarily exceed limit.
+// onEvicted is called on the background goroutine for those evictions,
+// concurrently with other callers. Close must be called to stop the
+// goroutine.
+func NewWithAccountingBackgroundEvict(limit, lowWatermark int, onAccount simplelru.AccountCallback, onEvicted func(key, value interface{})) (*AccountingCache, error) {
+	if lowWatermark < 0 || lowWatermark > limit {
+		return nil, errors.New("invalid low watermark")
+	}
+	c, err := NewWithAccounting(limit, onAccount, onEvicted)
+	if err != nil {
+		return nil, err
+	}
+	c.watermark, c.lowWatermark = lowWatermark, lowWatermark
+	c.evictCh = make(chan struct{}, 1)
+	c.closeCh = make(chan struct{})
+	c.doneCh = make(chan struct{})
+	go c.backgroundEvict(c.evictCh, c.closeCh, c.doneCh)
+	return c, nil
+}
+
+func (c *AccountingCache) initEvictBuffers() {
+	c.evictedKeys = make([]interface{}, 0, DefaultEvictedBufferSize)
+	c.evictedVals = make([]interface{}, 0, DefaultEvictedBufferSize)
+}
+
+// onEvicted save evicted key/val and sent in externally registered callback
+// outside of critical section
+func (c *AccountingCache) onEvicted(k, v interface{}) {
+	c.evictedKeys = append(c.evictedKeys, k)
+	c.evictedVals = append(c.evictedVals, v)
+}
+
+// takeEvicted hands over the buffered evictions. Must be called with the
+// lock held.
+func (c *AccountingCache) takeEvicted() (ks, vs []interface{}) {
+	if c.onEvictedCB == nil || len(c.evictedKeys) == 0 {
+		return nil, nil
+	}
+	ks, vs = c.evictedKeys, c.evictedVals
+	c.initEvictBuffers()
+	return ks, vs
+}
+
+// fireEvicted invokes the externally registered callback, outside of the
+// critical section.
+func (c *AccountingCache) fireEvicted(ks, vs []interface{}) {
+	if c.onEvictedCB == nil {
+		return
+	}
+	for i := 0; i < len(ks); i++ {
+		c.onEvictedCB(ks[i], vs[i])
+	}
+}
+
+// backgroundEvict trims the cache down to the low watermark every time it
+// is signalled, until closeCh is closed.
+func (c *AccountingCache) backgroundEvict(evictCh, closeCh <-chan struct{}, doneCh chan<- struct{}) {
+	defer close(doneCh)
+	for {
+		select {
+		case <-evictCh:
+			c.lock.Lock()
+			if !c.signalledAt.IsZero() {
+				if lag := c.lru.Clock().Since(c.signalledAt); lag > evictorLagThreshold {
+					c.logLocked(simplelru.EventEvictorLag, "lag", lag, "size", c.lru.AccountingSize(), "limit", c.lru.Limit())
+				}
+				c.signalledAt = time.Time{}
+			}
+			trim := c.lru.AccountingSize() > c.lru.Limit()
+			c.lock.Unlock()
+			total := 0
+			for trim {
+				select {
+				case <-closeCh:
+					return
+				default:
+				}
+				var evicted int
+				evicted, trim = c.evictBatch()
+				total += evicted
+			}
+			c.checkEvictionStorm(total)
+		case <-closeCh:
+			return
+		}
+	}
+}
+
+// evictBatch evicts at most backgroundEvictBatch entries towards the low
+// watermark. Returns the number evicted, and true if more remain to be
+// evicted.
+func (c *AccountingCache) evictBatch() (evicted int, more bool) {
+	c.lock.Lock()
+	evicted = c.lru.EvictToBounded(c.lowWatermark, backgroundEvictBatch)
+	more = evicted > 0 && c.lru.AccountingSize() > c.lowWatermark
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+	return evicted, more
+}
+
+// checkEvictionStorm logs an event if a whole background trim evicted a
+// lot, however many batches it took.
+func (c *AccountingCache) checkEvictionStorm(evicted int) {
+	if evicted < simplelru.EvictionStormThreshold {
+		return
+	}
+	c.lock.RLock()
+	c.logLocked(simplelru.EventEvictionStorm, "evicted", evicted, "size", c.lru.AccountingSize(), "limit", c.lru.Limit())
+	c.lock.RUnlock()
+}
+
+// logLocked sends an event to the Logger, if any. Must be called with the
+// lock held.
+func (c *AccountingCache) logLocked(event string, keyvals ...interface{}) {
+	if l := c.lru.Logger(); l != nil {
+		l.Log(c.lru.Name(), event, keyvals...)
+	}
+}
+
+// markSignalled records when the background evictor was first asked to run
+// since it last ran. Must be called with the lock held.
+func (c *AccountingCache) markSignalled() {
+	if c.signalledAt.IsZero() {
+		c.signalledAt = c.lru.Clock().Now()
+	}
+}
+
+// signalEvict wakes up the background goroutine without blocking.
+func (c *AccountingCache) signalEvict(evictCh chan<- struct{}) {
+	select {
+	case evictCh <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the background eviction goroutine, if any, and brings the
+// cache back within its limit. Afterwards Add evicts inline.
+func (c *AccountingCache) Close() {
+	c.lock.Lock()
+	if c.evictCh == nil {
+		c.lock.Unlock()
+		return
+	}
+	close(c.closeCh)
+	c.evictCh = nil
+	c.lock.Unlock()
+	<-c.doneCh
+
+	c.lock.Lock()
+	c.lru.EvictTo(c.lru.Limit())
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+}
+
+// SetClock sets the clock used to compute entry ages. A nil clock restores
+// the system clock.
+func (c *AccountingCache) SetClock(clock simplelru.Clock) {
+	c.lock.Lock()
+	c.lru.SetClock(clock)
+	c.lock.Unlock()
+}
+
+// SetName names the cache in the events sent to its Logger.
+func (c *AccountingCache) SetName(name string) {
+	c.lock.Lock()
+	c.lru.SetName(name)
+	c.lock.Unlock()
+}
+
+// SetLogger sets the Logger receiving events about unusual conditions, such
+// as oversized entries, accounting drift, eviction storms or a lagging
+// background evictor. A nil logger disables logging.
+func (c *AccountingCache) SetLogger(logger simplelru.Logger) {
+	c.lock.Lock()
+	c.lru.SetLogger(logger)
+	c.lock.Unlock()
+}
+
+// SetCompressionThreshold makes the cache store []byte values longer than
+// threshold bytes compressed, accounting them at their compressed size and
+// inflating them transparently on reads. A threshold of 0 disables it.
+func (c *AccountingCache) SetCompressionThreshold(threshold int) {
+	c.lock.Lock()
+	c.lru.SetCompressionThreshold(threshold)
+	c.lock.Unlock()
+}
+
+// SetSampledEviction makes the cache reclaim space by evicting a weighted
+// random sample of its coldest sampleSize entries instead of strictly the
+// oldest ones. A sampleSize of 0 restores strict LRU eviction.
+func (c *AccountingCache) SetSampledEviction(sampleSize int) error {
+	c.lock.Lock()
+	err := c.lru.SetSampledEviction(sampleSize)
+	c.lock.Unlock()
+	return err
+}
+
+// SetAuditLog keeps the last capacity administrative operations, such as
+// Resize, Purge and policy changes, with their before/after sizes. A
+// capacity of 0 disables the log.
+func (c *AccountingCache) SetAuditLog(capacity int) {
+	c.lock.Lock()
+	c.lru.SetAuditLog(capacity)
+	c.lock.Unlock()
+}
+
+// AuditLog returns the recorded administrative operations, from oldest to
+// newest.
+func (c *AccountingCache) AuditLog() []simplelru.AuditRecord {
+	c.lock.RLock()
+	records := c.lru.AuditLog()
+	c.lock.RUnlock()
+	return records
+}
+
+// Purge is used to completely clear the cache.
+func (c *AccountingCache) Purge() {
+	c.lock.Lock()
+	c.lru.Purge()
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+}
+
+// Add adds a value to the cache. Returns true if an eviction occurred.
+// With background eviction, Add never evicts and always returns false.
+func (c *AccountingCache) Add(key, value interface{}) (evicted bool) {
+	var overLimit bool
+	c.lock.Lock()
+	evictCh := c.evictCh
+	if evictCh != nil {
+		overLimit = c.lru.AddNoEvict(key, value)
+		if overLimit {
+			c.markSignalled()
+		}
+	} else {
+		evicted = c.lru.Add(key, value)
+	}
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+	if overLimit {
+		c.signalEvict(evictCh)
+	}
+	return
+}
+
+// AddBounded adds a value to the cache, evicting at most budget entries
+// inline to bound its latency. Returns whether an eviction occurred and
+// whether the cache is temporarily over its limit. The remaining overshoot
+// is handed to the background evictor if there is one, and is otherwise
+// trimmed by subsequent calls.
+func (c *AccountingCache) AddBounded(key, value interface{}, budget int) (evicted, overLimit bool) {
+	c.lock.Lock()
+	evictCh := c.evictCh
+	evicted, overLimit = c.lru.AddBounded(key, value, budget)
+	if overLimit && evictCh != nil {
+		c.markSignalled()
+	}
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+	if overLimit && evictCh != nil {
+		c.signalEvict(evictCh)
+	}
+	return
+}
+
+// Get looks up a key's value from the cache.
+func (c *AccountingCache) Get(key interface{}) (value interface{}, ok bool) {
+	c.lock.Lock()
+	value, ok = c.lru.Get(key)
+	c.lock.Unlock()
+	return value, ok
+}
+
+// Contains checks if a key is in the cache, without updating the
+// recent-ness or deleting it for being stale.
+func (c *AccountingCache) Contains(key interface{}) bool {
+	c.lock.RLock()
+	containKey := c.lru.Contains(key)
+	c.lock.RUnlock()
+	return containKey
+}
+
+// Peek returns the key value (or undefined if not found) without updating
+// the "recently used"-ness of the key.
+func (c *AccountingCache) Peek(key interface{}) (value interface{}, ok bool) {
+	c.lock.RLock()
+	value, ok = c.lru.Peek(key)
+	c.lock.RUnlock()
+	return value, ok
+}
+
+// Remove removes the provided key from the cache.
+func (c *AccountingCache) Remove(key interface{}) (present bool) {
+	c.lock.Lock()
+	present = c.lru.Remove(key)
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+	return
+}
+
+// Take removes the provided key from the cache and returns its value,
+// without invoking the eviction callback.
+func (c *AccountingCache) Take(key interface{}) (value interface{}, ok bool) {
+	c.lock.Lock()
+	value, ok = c.lru.Take(key)
+	c.lock.Unlock()
+	return value, ok
+}
+
+// SetDeferredRemoval controls whether Remove defers the eviction callback
+// until the next call to Reap.
+func (c *AccountingCache) SetDeferredRemoval(enabled bool) {
+	c.lock.Lock()
+	c.lru.SetDeferredRemoval(enabled)
+	c.lock.Unlock()
+}
+
+// Reap invokes the eviction callback for every entry removed since the last
+// call, outside of the critical section. Returns the number reaped.
+func (c *AccountingCache) Reap() (reaped int) {
+	c.lock.Lock()
+	reaped = c.lru.Reap()
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+	return reaped
+}
+
+// Tombstones returns the number of removed entries waiting for Reap.
+func (c *AccountingCache) Tombstones() int {
+	c.lock.RLock()
+	n := c.lru.Tombstones()
+	c.lock.RUnlock()
+	return n
+}
+
+// Resize changes the cache limit, evicting the oldest entries until the
+// accounting size fits within it. Returns the number of entries evicted.
+// With background eviction, the evictor is signalled instead and Resize
+// returns 0.
+func (c *AccountingCache) Resize(size int) (evicted int) {
+	var overLimit bool
+	c.lock.Lock()
+	evictCh := c.evictCh
+	c.lowWatermark = c.watermark
+	if c.lowWatermark > size {
+		c.lowWatermark = size
+	}
+	if evictCh != nil {
+		overLimit = c.lru.ResizeNoEvict(size)
+		if overLimit {
+			c.markSignalled()
+		}
+	} else {
+		evicted = c.lru.Resize(size)
+	}
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+	if overLimit {
+		c.signalEvict(evictCh)
+	}
+	return evicted
+}
+
+// RemoveOldest removes the oldest item from the cache.
+func (c *AccountingCache) RemoveOldest() (key, value interface{}, ok bool) {
+	c.lock.Lock()
+	key, value, ok = c.lru.RemoveOldest()
+	ks, vs := c.takeEvicted()
+	c.lock.Unlock()
+	c.fireEvicted(ks, vs)
+	return
+}
+
+// GetOldest returns the oldest entry
+func (c *AccountingCache) GetOldest() (key, value interface{}, ok bool) {
+	c.lock.RLock()
+	key, value, ok = c.lru.GetOldest()
+	c.lock.RUnlock()
+	return
+}
+
+// GetNewest returns the newest entry
+func (c *AccountingCache) GetNewest() (key, value interface{}, ok bool) {
+	c.lock.RLock()
+	key, value, ok = c.lru.GetNewest()
+	c.lock.RUnlock()
+	return
+}
+
+// GetOldestEntry returns the oldest entry along with its accounted weight
+// and age.
+func (c *AccountingCache) GetOldestEntry() (ent simplelru.Entry, ok bool) {
+	c.lock.RLock()
+	ent, ok = c.lru.GetOldestEntry()
+	c.lock.RUnlock()
+	return
+}
+
+// GetNewestEntry returns the newest entry along with its accounted weight
+// and age.
+func (c *AccountingCache) GetNewestEntry() (ent simplelru.Entry, ok bool) {
+	c.lock.RLock()
+	ent, ok = c.lru.GetNewestEntry()
+	c.lock.RUnlock()
+	return
+}
+
+// PeekNewestWithin returns the most recently used entries, from newest to
+// oldest, whose cumulative accounted weight fits within budget.
+func (c *AccountingCache) PeekNewestWithin(budget int) []simplelru.Entry {
+	c.lock.RLock()
+	entries := c.lru.PeekNewestWithin(budget)
+	c.lock.RUnlock()
+	return entries
+}
+
+// ExportOrdered streams every entry to w, from oldest to newest, along with
+// its accounted weight. The cache is only locked while the entries are
+// copied; marshal runs and w is written after it is released, so a slow
+// writer does not block other callers.
+func (c *AccountingCache) ExportOrdered(w io.Writer, marshal simplelru.MarshalFunc) error {
+	c.lock.RLock()
+	export := c.lru.SnapshotOrdered()
+	c.lock.RUnlock()
+	return export.Stream(w, marshal)
+}
+
+// ImportOrdered adds the entries of a stream written by ExportOrdered,
+// reproducing their recency order and weights. Records are read and
+// unmarshalled without holding the lock, which is only taken to add each
+// batch of importBatch entries, so a slow reader does not block other
+// callers. Returns the number of entries read.
+func (c *AccountingCache) ImportOrdered(r io.Reader, unmarshal simplelru.UnmarshalFunc) (n int, err error) {
+	x := simplelru.NewOrderedImport(r, unmarshal)
+	for {
+		batch, done, err := x.Next(importBatch)
+		c.lock.Lock()
+		n += c.lru.AddImported(batch)
+		ks, vs := c.takeEvicted()
+		c.lock.Unlock()
+		c.fireEvicted(ks, vs)
+		if err != nil || done {
+			return n, err
+		}
+	}
+}
+
+// Keys returns a slice of the keys in the cache, from oldest to newest.
+func (c *AccountingCache) Keys() []interface{} {
+	c.lock.RLock()
+	keys := c.lru.Keys()
+	c.lock.RUnlock()
+	return keys
+}
+
+// KeysWhere returns the keys of the entries for which pred returns true,
+// from oldest to newest. pred is evaluated under the lock in a single pass
+// and must not call back into the cache.
+func (c *AccountingCache) KeysWhere(pred func(key, value interface{}) bool) []interface{} {
+	c.lock.RLock()
+	keys := c.lru.KeysWhere(pred)
+	c.lock.RUnlock()
+	return keys
+}
+
+// Position returns the distance of key from eviction, 0 being the next
+// entry to be evicted and Len()-1 the most recently used one.
+func (c *AccountingCache) Position(key interface{}) (pos int, ok bool) {
+	c.lock.RLock()
+	pos, ok = c.lru.Position(key)
+	c.lock.RUnlock()
+	return pos, ok
+}
+
+// Len returns the number of items in the cache.
+func (c *AccountingCache) Len() int {
+	c.lock.RLock()
+	length := c.lru.Len()
+	c.lock.RUnlock()
+	return length
+}
+
+// AccountingSize returns the size of the cache measured by the accounting
+// callback.
+func (c *AccountingCache) AccountingSize() int {
+	c.lock.RLock()
+	size := c.lru.AccountingSize()
+	c.lock.RUnlock()
+	return size
+}
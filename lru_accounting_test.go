@@ -0,0 +1,351 @@
+package lru
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func accountBytes(k interface{}, v interface{}) int {
+	return len(k.(string)) + len(v.([]byte))
+}
+
+func TestAccountingCache(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		if k != string(v.([]byte)) {
+			t.Fatalf("Evict values not equal (%v!=%v)", k, v)
+		}
+		evictCounter++
+	}
+	l, err := NewWithAccounting(10, accountBytes, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		l.Add(fmt.Sprint(i), []byte(fmt.Sprint(i)))
+	}
+	if l.AccountingSize() != 10 {
+		t.Fatalf("bad size: %v", l.AccountingSize())
+	}
+	if evictCounter != 5 {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+	for i, k := range l.Keys() {
+		if v, ok := l.Get(k); !ok || string(v.([]byte)) != fmt.Sprint(i+5) {
+			t.Fatalf("bad key: %v", k)
+		}
+	}
+	if !l.Remove("5") {
+		t.Fatalf("should be contained")
+	}
+	if evictCounter != 6 {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+
+	// Resize evicts by accounting size, not by entry count.
+	if evicted := l.Resize(4); evicted != 2 {
+		t.Fatalf("bad evicted: %v", evicted)
+	}
+	if l.AccountingSize() != 4 || l.Len() != 2 {
+		t.Fatalf("bad size: %v, len: %v", l.AccountingSize(), l.Len())
+	}
+	if evictCounter != 8 {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+
+	l.Purge()
+	if l.Len() != 0 || l.AccountingSize() != 0 {
+		t.Fatalf("bad len: %v", l.Len())
+	}
+	if evictCounter != 10 {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+}
+
+func TestAccountingCache_BackgroundEvict(t *testing.T) {
+	var mu sync.Mutex
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		mu.Lock()
+		evictCounter++
+		mu.Unlock()
+	}
+	if _, err := NewWithAccountingBackgroundEvict(10, 11, accountBytes, nil); err == nil {
+		t.Fatalf("expected error for low watermark above limit")
+	}
+	l, err := NewWithAccountingBackgroundEvict(10, 4, accountBytes, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		if l.Add(fmt.Sprint(i), []byte(fmt.Sprint(i))) {
+			t.Fatalf("Add should never evict inline")
+		}
+	}
+	if l.AccountingSize() != 10 {
+		t.Fatalf("bad size: %v", l.AccountingSize())
+	}
+
+	// Going over the limit only signals the evictor.
+	if l.Add("5", []byte("5")) {
+		t.Fatalf("Add should never evict inline")
+	}
+
+	// The callbacks fire after the lock is released, so wait on the
+	// counter rather than on the size.
+	waitEvicted := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			count := evictCounter
+			mu.Unlock()
+			if count == n {
+				return
+			}
+			if count > n || time.Now().After(deadline) {
+				t.Fatalf("bad evict count: %v", count)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitEvicted(4)
+	if l.AccountingSize() != 4 {
+		t.Fatalf("bad size: %v", l.AccountingSize())
+	}
+	for i, k := range l.Keys() {
+		if k != fmt.Sprint(i+4) {
+			t.Fatalf("bad key: %v", k)
+		}
+	}
+
+	// Shrinking signals the evictor too, which trims to the clamped low
+	// watermark.
+	if evicted := l.Resize(2); evicted != 0 {
+		t.Fatalf("Resize should not evict inline: %v", evicted)
+	}
+	waitEvicted(5)
+	if l.AccountingSize() != 2 || !l.Contains("5") {
+		t.Fatalf("bad size: %v, keys: %v", l.AccountingSize(), l.Keys())
+	}
+
+	// Growing back restores the configured low watermark.
+	l.Resize(10)
+	for i := 6; i < 10; i++ {
+		l.Add(fmt.Sprint(i), []byte(fmt.Sprint(i)))
+	}
+	l.Add("a", []byte("a"))
+	waitEvicted(9)
+	if l.AccountingSize() != 4 {
+		t.Fatalf("bad size: %v, keys: %v", l.AccountingSize(), l.Keys())
+	}
+
+	// Once closed, eviction happens inline again.
+	l.Close()
+	for _, k := range []string{"b", "c", "d"} {
+		l.Add(k, []byte(k))
+	}
+	if l.AccountingSize() != 10 {
+		t.Fatalf("bad size: %v", l.AccountingSize())
+	}
+	mu.Lock()
+	if evictCounter != 9 {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+	mu.Unlock()
+}
+
+func TestAccountingCache_Take(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		evictCounter++
+	}
+	l, err := NewWithAccounting(10, accountBytes, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add("a", []byte("1"))
+	if v, ok := l.Take("a"); !ok || string(v.([]byte)) != "1" {
+		t.Fatalf("bad take: %v, %v", v, ok)
+	}
+	if evictCounter != 0 {
+		t.Fatalf("bad evict count: %v", evictCounter)
+	}
+	if l.AccountingSize() != 0 {
+		t.Fatalf("bad size: %v", l.AccountingSize())
+	}
+}
+
+// slowClock makes every wait look like it lasted an hour.
+type slowClock struct{}
+
+func (slowClock) Now() time.Time { return time.Now() }
+
+func (slowClock) Since(t time.Time) time.Duration { return time.Hour }
+
+// eventLogger collects the logged events.
+type eventLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (e *eventLogger) Log(cache string, event string, keyvals ...interface{}) {
+	e.mu.Lock()
+	e.events = append(e.events, cache+":"+event)
+	e.mu.Unlock()
+}
+
+func TestAccountingCache_EvictorLag(t *testing.T) {
+	l, err := NewWithAccountingBackgroundEvict(4, 2, accountBytes, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+	logger := &eventLogger{}
+	l.SetName("peers")
+	l.SetLogger(logger)
+	l.SetClock(slowClock{})
+
+	l.Add("a", []byte("1"))
+	l.Add("b", []byte("2"))
+	l.Add("c", []byte("3"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for l.AccountingSize() > 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("background eviction did not run, size: %v", l.AccountingSize())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.events) != 1 || logger.events[0] != "peers:evictor_lag" {
+		t.Fatalf("bad events: %v", logger.events)
+	}
+}
+
+func TestAccountingCache_ExportOrderedUnlocked(t *testing.T) {
+	l, err := NewWithAccounting(100, accountBytes, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.Add("a", []byte("1"))
+	l.Add("b", []byte("2"))
+
+	// The cache must stay usable while the export is being marshalled
+	// and written, or these calls would deadlock.
+	marshal := func(key, value interface{}) ([]byte, []byte, error) {
+		l.Get("a")
+		l.Add("c", []byte("3"))
+		return []byte(key.(string)), value.([]byte), nil
+	}
+	var buf bytes.Buffer
+	if err := l.ExportOrdered(&buf, marshal); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	dst, err := NewWithAccounting(100, accountBytes, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	unmarshal := func(k, v []byte) (interface{}, interface{}, error) {
+		return string(k), v, nil
+	}
+	if n, err := dst.ImportOrdered(&buf, unmarshal); err != nil || n != 2 {
+		t.Fatalf("bad import: %v, %v", n, err)
+	}
+	if keys := dst.Keys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("export should be a snapshot: %v", keys)
+	}
+}
+
+func TestAccountingCache_BackgroundEvictStorm(t *testing.T) {
+	l, err := NewWithAccountingBackgroundEvict(10000, 0, func(k, v interface{}) int { return 1 }, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+	logger := &eventLogger{}
+	l.SetLogger(logger)
+
+	for i := 0; i < 1001; i++ {
+		l.Add(i, i)
+	}
+	// A single trim of 1001 entries takes many batches, but is only
+	// reported once.
+	l.Resize(10)
+	storms := func() (n int) {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		for _, event := range logger.events {
+			if event == ":eviction_storm" {
+				n++
+			}
+		}
+		return n
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for storms() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("eviction storm was not logged, size: %v", l.AccountingSize())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	l.Close()
+	if l.Len() != 0 || storms() != 1 {
+		t.Fatalf("bad storms: %v, size: %v", storms(), l.AccountingSize())
+	}
+}
+
+// callbackReader calls fn before every Read.
+type callbackReader struct {
+	r  io.Reader
+	fn func()
+}
+
+func (c *callbackReader) Read(p []byte) (int, error) {
+	c.fn()
+	return c.r.Read(p)
+}
+
+func TestAccountingCache_ImportOrderedUnlocked(t *testing.T) {
+	src, err := NewWithAccounting(1000, accountBytes, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		src.Add(fmt.Sprint(i), []byte("v"))
+	}
+	var buf bytes.Buffer
+	marshal := func(key, value interface{}) ([]byte, []byte, error) {
+		return []byte(key.(string)), value.([]byte), nil
+	}
+	if err := src.ExportOrdered(&buf, marshal); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The cache must stay usable while the stream is read and
+	// unmarshalled, or these calls would deadlock.
+	l, err := NewWithAccounting(1000, accountBytes, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r := &callbackReader{r: &buf, fn: func() { l.Get("0") }}
+	unmarshal := func(k, v []byte) (interface{}, interface{}, error) {
+		l.Contains("0")
+		return string(k), v, nil
+	}
+	if n, err := l.ImportOrdered(r, unmarshal); err != nil || n != 100 {
+		t.Fatalf("bad import: %v, %v", n, err)
+	}
+	if l.AccountingSize() != src.AccountingSize() {
+		t.Fatalf("bad size: %v", l.AccountingSize())
+	}
+}
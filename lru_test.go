@@ -291,3 +291,59 @@ func TestLRUResize(t *testing.T) {
 		t.Errorf("Cache should have contained 2 elements")
 	}
 }
+
+// test that KeysWhere filters entries under a single lock
+func TestLRUKeysWhere(t *testing.T) {
+	l, err := New(8)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		l.Add(i, i)
+	}
+	keys := l.KeysWhere(func(k, v interface{}) bool {
+		return k.(int) >= 6
+	})
+	if len(keys) != 2 || keys[0] != 6 || keys[1] != 7 {
+		t.Errorf("bad keys: %v", keys)
+	}
+}
+
+// test that Remove invokes the callback, inline or on Reap
+func TestLRURemoveReap(t *testing.T) {
+	var evicted []interface{}
+	l, err := NewWithEvict(4, func(k, v interface{}) {
+		evicted = append(evicted, k)
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Add(3, 3)
+	l.Remove(1)
+	if len(evicted) != 1 || evicted[0] != 1 {
+		t.Errorf("bad evictions: %v", evicted)
+	}
+
+	l.SetDeferredRemoval(true)
+	l.Remove(2)
+	l.Remove(3)
+	if len(evicted) != 1 || l.Tombstones() != 2 {
+		t.Errorf("removal should have been deferred: %v", evicted)
+	}
+	if n := l.Reap(); n != 2 {
+		t.Errorf("bad reap count: %v", n)
+	}
+	if len(evicted) != 3 || evicted[1] != 2 || evicted[2] != 3 {
+		t.Errorf("bad evictions: %v", evicted)
+	}
+	l.Add(4, 4)
+	l.Remove(4)
+	l.Add(4, 4)
+	if len(evicted) != 4 || evicted[3] != 4 || l.Tombstones() != 0 {
+		t.Errorf("re-adding should reap the tombstone: %v", evicted)
+	}
+}
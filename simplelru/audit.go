@@ -0,0 +1,158 @@
+package simplelru
+
+import (
+	"fmt"
+	"time"
+)
+
+// AuditRecord describes an administrative operation on a cache, such as a
+// Resize, a Purge or a change of policy.
+type AuditRecord struct {
+	// Time is when the operation completed, according to the cache Clock.
+	Time time.Time
+	// Op is the name of the method, e.g. "Resize".
+	Op string
+	// Detail holds the new setting for policy changes.
+	Detail string
+	// LimitBefore and LimitAfter are the capacity of the cache: a number
+	// of entries for LRU, an accounting size for LRUWithAccounting.
+	LimitBefore, LimitAfter int
+	// UsedBefore and UsedAfter are how much of that capacity was in use.
+	UsedBefore, UsedAfter int
+}
+
+// auditState is the part of an AuditRecord captured before an operation.
+type auditState struct {
+	limit, used int
+}
+
+// auditLog is a bounded log of AuditRecords, dropping the oldest ones once
+// full.
+type auditLog struct {
+	records []AuditRecord
+	next    int
+	full    bool
+}
+
+func newAuditLog(capacity int) *auditLog {
+	return &auditLog{records: make([]AuditRecord, capacity)}
+}
+
+func (a *auditLog) add(r AuditRecord) {
+	a.records[a.next] = r
+	a.next++
+	if a.next == len(a.records) {
+		a.next = 0
+		a.full = true
+	}
+}
+
+// resized returns a log of the given capacity holding the newest records of
+// a, which may be nil.  A capacity of 0 returns nil.
+func (a *auditLog) resized(capacity int) *auditLog {
+	if capacity <= 0 {
+		return nil
+	}
+	r := newAuditLog(capacity)
+	if a != nil {
+		records := a.snapshot()
+		if len(records) > capacity {
+			records = records[len(records)-capacity:]
+		}
+		for _, record := range records {
+			r.add(record)
+		}
+	}
+	return r
+}
+
+// snapshot returns a copy of the records, from oldest to newest.
+func (a *auditLog) snapshot() []AuditRecord {
+	if !a.full {
+		return append([]AuditRecord(nil), a.records[:a.next]...)
+	}
+	out := make([]AuditRecord, 0, len(a.records))
+	out = append(out, a.records[a.next:]...)
+	return append(out, a.records[:a.next]...)
+}
+
+// SetAuditLog keeps the last capacity administrative operations (Resize,
+// Purge, SetInsertionPoint, SetDeferredRemoval) in memory for AuditLog.
+// Changing the capacity keeps the newest records that still fit.  A
+// capacity of 0 disables the log and drops its records.
+func (c *LRU) SetAuditLog(capacity int) {
+	c.audit = c.audit.resized(capacity)
+}
+
+// AuditLog returns the recorded administrative operations, from oldest to
+// newest.
+func (c *LRU) AuditLog() []AuditRecord {
+	if c.audit == nil {
+		return nil
+	}
+	return c.audit.snapshot()
+}
+
+func (c *LRU) auditState() auditState {
+	return auditState{limit: c.size, used: c.evictList.Len()}
+}
+
+// recordAudit logs an operation that started in state before.
+func (c *LRU) recordAudit(op string, detail string, before auditState) {
+	if c.audit == nil {
+		return
+	}
+	after := c.auditState()
+	c.audit.add(AuditRecord{
+		Time:        c.clock.Now(),
+		Op:          op,
+		Detail:      detail,
+		LimitBefore: before.limit,
+		LimitAfter:  after.limit,
+		UsedBefore:  before.used,
+		UsedAfter:   after.used,
+	})
+}
+
+// SetAuditLog keeps the last capacity administrative operations (Resize,
+// Purge, SetSampledEviction, SetCompressionThreshold, SetDeferredRemoval) in
+// memory for AuditLog.  Changing the capacity keeps the newest records that
+// still fit.  A capacity of 0 disables the log and drops its records.
+func (c *LRUWithAccounting) SetAuditLog(capacity int) {
+	c.audit = c.audit.resized(capacity)
+}
+
+// AuditLog returns the recorded administrative operations, from oldest to
+// newest.
+func (c *LRUWithAccounting) AuditLog() []AuditRecord {
+	if c.audit == nil {
+		return nil
+	}
+	return c.audit.snapshot()
+}
+
+func (c *LRUWithAccounting) auditState() auditState {
+	return auditState{limit: c.limit, used: c.size}
+}
+
+// recordAudit logs an operation that started in state before.
+func (c *LRUWithAccounting) recordAudit(op string, detail string, before auditState) {
+	if c.audit == nil {
+		return
+	}
+	after := c.auditState()
+	c.audit.add(AuditRecord{
+		Time:        c.clock.Now(),
+		Op:          op,
+		Detail:      detail,
+		LimitBefore: before.limit,
+		LimitAfter:  after.limit,
+		UsedBefore:  before.used,
+		UsedAfter:   after.used,
+	})
+}
+
+// auditDetail formats the new setting of a policy change.
+func auditDetail(name string, value interface{}) string {
+	return fmt.Sprintf("%s=%v", name, value)
+}
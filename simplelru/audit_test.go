@@ -0,0 +1,97 @@
+package simplelru
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestLRU_AuditLog(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetClock(clock)
+
+	l.Resize(8)
+	if l.AuditLog() != nil {
+		t.Fatalf("audit log should be disabled by default")
+	}
+
+	l.SetAuditLog(2)
+	for i := 0; i < 6; i++ {
+		l.Add(i, i)
+	}
+	clock.Advance(time.Second)
+	l.Resize(3)
+	clock.Advance(time.Second)
+	assert.NilError(t, l.SetInsertionPoint(0.5))
+	clock.Advance(time.Second)
+	l.Purge()
+
+	// Only the last two operations are kept.
+	records := l.AuditLog()
+	assert.DeepEqual(t, records, []AuditRecord{
+		{
+			Time:        time.Unix(1002, 0),
+			Op:          "SetInsertionPoint",
+			Detail:      "coldRatio=0.5",
+			LimitBefore: 3, LimitAfter: 3,
+			UsedBefore: 3, UsedAfter: 3,
+		},
+		{
+			Time:        time.Unix(1003, 0),
+			Op:          "Purge",
+			LimitBefore: 3, LimitAfter: 3,
+			UsedBefore: 3, UsedAfter: 0,
+		},
+	})
+
+	// Resizing the log keeps the newest records.
+	l.SetAuditLog(4)
+	assert.DeepEqual(t, l.AuditLog(), records)
+	l.SetDeferredRemoval(true)
+	l.SetAuditLog(2)
+	records = l.AuditLog()
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, records[0].Op, "Purge")
+	assert.Equal(t, records[1].Op, "SetDeferredRemoval")
+	assert.Equal(t, records[1].Detail, "enabled=true")
+
+	l.SetAuditLog(0)
+	l.Purge()
+	if l.AuditLog() != nil {
+		t.Fatalf("audit log should be disabled")
+	}
+}
+
+func TestLRUWithAccounting_AuditLog(t *testing.T) {
+	l, err := NewLRUWithAccounting(10, func(k, v interface{}) int { return 2 }, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetAuditLog(8)
+
+	for i := 0; i < 5; i++ {
+		l.Add(i, i)
+	}
+	l.Resize(4)
+	assert.NilError(t, l.SetSampledEviction(4))
+	l.SetCompressionThreshold(128)
+	l.SetDeferredRemoval(true)
+
+	records := l.AuditLog()
+	assert.Equal(t, len(records), 4)
+	assert.Equal(t, records[0].Op, "Resize")
+	assert.Equal(t, records[0].LimitBefore, 10)
+	assert.Equal(t, records[0].LimitAfter, 4)
+	assert.Equal(t, records[0].UsedBefore, 10)
+	assert.Equal(t, records[1].Op, "SetSampledEviction")
+	assert.Equal(t, records[1].Detail, "sampleSize=4")
+	assert.Equal(t, records[2].Op, "SetCompressionThreshold")
+	assert.Equal(t, records[2].Detail, "threshold=128")
+	assert.Equal(t, records[3].Op, "SetDeferredRemoval")
+	assert.Equal(t, records[3].Detail, "enabled=true")
+}
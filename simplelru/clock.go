@@ -0,0 +1,21 @@
+package simplelru
+
+import "time"
+
+// Clock is the source of time for the time-based features of the caches,
+// such as entry ages.  Embedders can supply their own to drive cache time
+// explicitly, e.g. in simulations or tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+}
+
+// systemClock is the Clock backed by the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
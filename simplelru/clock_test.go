@@ -0,0 +1,50 @@
+package simplelru
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time { return f.now }
+
+func (f *fakeClock) Since(t time.Time) time.Duration { return f.now.Sub(t) }
+
+func (f *fakeClock) Advance(d time.Duration) { f.now = f.now.Add(d) }
+
+func TestLRUWithAccounting_SetClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l, err := NewLRUWithAccounting(10, func(k, v interface{}) int { return 1 }, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetClock(clock)
+
+	l.Add(1, 1)
+	clock.Advance(time.Minute)
+	l.Add(2, 2)
+	clock.Advance(time.Second)
+
+	oldest, _ := l.GetOldestEntry()
+	assert.Equal(t, oldest.Age, time.Minute+time.Second)
+	newest, _ := l.GetNewestEntry()
+	assert.Equal(t, newest.Age, time.Second)
+
+	// Rewriting an entry resets its age.
+	l.Add(1, 1)
+	newest, _ = l.GetNewestEntry()
+	assert.Equal(t, newest.Key, 1)
+	assert.Equal(t, newest.Age, time.Duration(0))
+
+	l.SetClock(nil)
+	newest, _ = l.GetNewestEntry()
+	if newest.Age <= time.Hour {
+		t.Fatalf("system clock should be far past the fake one: %v", newest.Age)
+	}
+}
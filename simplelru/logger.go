@@ -0,0 +1,34 @@
+package simplelru
+
+// Events reported to a Logger.
+const (
+	// EventOversizedEntry is logged when an entry heavier than the whole
+	// limit is added, which leaves no room for it or anything else.
+	EventOversizedEntry = "oversized_entry"
+
+	// EventAccountingDrift is logged when an entry being removed no longer
+	// weighs what it was accounted for, e.g. because its value was mutated
+	// in place.
+	EventAccountingDrift = "accounting_drift"
+
+	// EventEvictionStorm is logged when a single call evicts at least
+	// EvictionStormThreshold entries.
+	EventEvictionStorm = "eviction_storm"
+
+	// EventEvictorLag is logged when a background evictor starts trimming
+	// long after it was signalled.
+	EventEvictorLag = "evictor_lag"
+)
+
+// EvictionStormThreshold is the number of evictions by a single call above
+// which EventEvictionStorm is logged.
+const EvictionStormThreshold = 64
+
+// Logger receives structured events about unusual cache conditions.  It is
+// called from within cache operations, possibly under the cache lock, and
+// must not call back into the cache.
+type Logger interface {
+	// Log reports event, one of the Event constants, for the named cache
+	// along with alternating key/value pairs describing it.
+	Log(cache string, event string, keyvals ...interface{})
+}
@@ -0,0 +1,63 @@
+package simplelru
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// recordingLogger collects the logged events.
+type recordingLogger struct {
+	caches []string
+	events []string
+}
+
+func (r *recordingLogger) Log(cache string, event string, keyvals ...interface{}) {
+	if len(keyvals)%2 != 0 {
+		panic("odd number of keyvals")
+	}
+	r.caches = append(r.caches, cache)
+	r.events = append(r.events, event)
+}
+
+func TestLRUWithAccounting_Logger(t *testing.T) {
+	weights := map[interface{}]int{}
+	onAccount := func(k interface{}, v interface{}) int {
+		return weights[k]
+	}
+	l, err := NewLRUWithAccounting(100, onAccount, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	logger := &recordingLogger{}
+	l.SetName("state")
+	l.SetLogger(logger)
+	assert.Equal(t, l.Name(), "state")
+
+	// Evicting many entries at once is a storm.
+	for i := 0; i < 100; i++ {
+		weights[i] = 1
+		l.Add(i, i)
+	}
+	weights["big"] = 100
+	l.Add("big", "big")
+	assert.DeepEqual(t, logger.events, []string{EventEvictionStorm})
+	assert.DeepEqual(t, logger.caches, []string{"state"})
+
+	// An entry heavier than the limit cannot fit.
+	weights["huge"] = 101
+	l.Add("huge", "huge")
+	assert.DeepEqual(t, logger.events, []string{EventEvictionStorm, EventOversizedEntry})
+
+	// Removing an entry whose weight changed behind our back is a drift.
+	logger.events = nil
+	weights["a"] = 1
+	l.Add("a", "a")
+	weights["a"] = 2
+	l.Remove("a")
+	assert.DeepEqual(t, logger.events, []string{EventAccountingDrift})
+
+	l.SetLogger(nil)
+	l.Add("huge", "huge")
+	assert.DeepEqual(t, logger.events, []string{EventAccountingDrift})
+}
@@ -3,6 +3,7 @@ package simplelru
 import (
 	"container/list"
 	"errors"
+	"math"
 )
 
 // EvictCallback is used to get a callback when a cache entry is evicted
@@ -14,12 +15,29 @@ type LRU struct {
 	evictList *list.List
 	items     map[interface{}]*list.Element
 	onEvict   EvictCallback
+
+	// Midpoint insertion, see SetInsertionPoint. coldHead is the newest
+	// entry of the cold region, which spans coldLen entries up to the back
+	// of the evictList.
+	coldRatio float64
+	coldHead  *list.Element
+	coldLen   int
+
+	// Deferred removal, see SetDeferredRemoval. tombstones holds the
+	// removed entries in removal order, tombstoned indexes it by key.
+	deferRemoval bool
+	tombstones   *list.List
+	tombstoned   map[interface{}]*list.Element
+
+	clock Clock
+	audit *auditLog
 }
 
 // entry is used to hold a value in the evictList
 type entry struct {
 	key   interface{}
 	value interface{}
+	cold  bool
 }
 
 // NewLRU constructs an LRU of the given size
@@ -28,16 +46,29 @@ func NewLRU(size int, onEvict EvictCallback) (*LRU, error) {
 		return nil, errors.New("must provide a positive size")
 	}
 	c := &LRU{
-		size:      size,
-		evictList: list.New(),
-		items:     make(map[interface{}]*list.Element),
-		onEvict:   onEvict,
+		size:       size,
+		evictList:  list.New(),
+		items:      make(map[interface{}]*list.Element),
+		onEvict:    onEvict,
+		tombstones: list.New(),
+		tombstoned: make(map[interface{}]*list.Element),
+		clock:      systemClock{},
 	}
 	return c, nil
 }
 
+// SetClock sets the clock used to timestamp audit records.  A nil clock
+// restores the system clock.
+func (c *LRU) SetClock(clock Clock) {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	c.clock = clock
+}
+
 // Purge is used to completely clear the cache.
 func (c *LRU) Purge() {
+	defer c.recordAudit("Purge", "", c.auditState())
 	for k, v := range c.items {
 		if c.onEvict != nil {
 			c.onEvict(k, v.Value.(*entry).value)
@@ -45,23 +76,95 @@ func (c *LRU) Purge() {
 		delete(c.items, k)
 	}
 	c.evictList.Init()
+	c.coldHead = nil
+	c.coldLen = 0
+	c.Reap()
+}
+
+// SetDeferredRemoval controls whether Remove defers the eviction callback.
+// When enabled, a removed entry immediately disappears from the cache, but
+// is kept as a tombstone and only reported to the eviction callback by the
+// next call to Reap, so that external indexes can be synchronized in
+// batches.  Tombstones still count towards the size of the cache, and are
+// reaped inline, oldest first, before any entry is evicted to make room.
+// Adding a tombstoned key again reaps its tombstone inline first, so the
+// callback never reports a key that is back in the cache.  Capacity
+// evictions still fire the callback inline.
+func (c *LRU) SetDeferredRemoval(enabled bool) {
+	defer c.recordAudit("SetDeferredRemoval", auditDetail("enabled", enabled), c.auditState())
+	c.deferRemoval = enabled
+}
+
+// Reap fires the eviction callback for every entry removed since the last
+// call, in removal order, and drops the tombstones.  Returns the number of
+// entries reaped.
+func (c *LRU) Reap() (reaped int) {
+	for c.tombstones.Len() > 0 {
+		c.reapElement(c.tombstones.Front())
+		reaped++
+	}
+	return reaped
+}
+
+// Tombstones returns the number of removed entries waiting for Reap.
+func (c *LRU) Tombstones() int {
+	return c.tombstones.Len()
+}
+
+// bury moves a removed element to the tombstones.
+func (c *LRU) bury(e *list.Element) {
+	kv := c.unlinkElement(e)
+	c.tombstoned[kv.key] = c.tombstones.PushBack(kv)
+}
+
+// reapElement drops a tombstone and fires the eviction callback for it.
+func (c *LRU) reapElement(t *list.Element) {
+	kv := c.tombstones.Remove(t).(*entry)
+	delete(c.tombstoned, kv.key)
+	if c.onEvict != nil {
+		c.onEvict(kv.key, kv.value)
+	}
+}
+
+// SetInsertionPoint makes new entries enter the cache ahead of the coldest
+// coldRatio fraction of it, instead of at the front: 0.5 inserts at the
+// midpoint, 0.375 mimics InnoDB's default.  The cold region is rounded up,
+// and holds at least two entries, so that a new entry is never the next one
+// to be evicted, however small the cache or the ratio.  Entries only reach
+// the front when they are accessed again, so a one-off scan can only evict
+// entries from the cold region.  A ratio of 0 restores plain LRU insertion.
+func (c *LRU) SetInsertionPoint(coldRatio float64) error {
+	if coldRatio < 0.0 || coldRatio >= 1.0 {
+		return errors.New("invalid cold ratio")
+	}
+	defer c.recordAudit("SetInsertionPoint", auditDetail("coldRatio", coldRatio), c.auditState())
+	c.coldRatio = coldRatio
+	c.rebalanceCold()
+	return nil
 }
 
 // Add adds a value to the cache.  Returns true if an eviction occurred.
 func (c *LRU) Add(key, value interface{}) (evicted bool) {
 	// Check for existing item
 	if ent, ok := c.items[key]; ok {
-		c.evictList.MoveToFront(ent)
+		c.promote(ent)
 		ent.Value.(*entry).value = value
 		return false
 	}
+	if t, ok := c.tombstoned[key]; ok {
+		c.reapElement(t)
+	}
+
+	if c.coldRatio > 0 {
+		return c.addCold(key, value)
+	}
 
 	// Add new item
-	ent := &entry{key, value}
+	ent := &entry{key: key, value: value}
 	entry := c.evictList.PushFront(ent)
 	c.items[key] = entry
 
-	evict := c.evictList.Len() > c.size
+	evict := c.evictList.Len()+c.tombstones.Len() > c.size
 	// Verify size not exceeded
 	if evict {
 		c.removeOldest()
@@ -69,10 +172,97 @@ func (c *LRU) Add(key, value interface{}) (evicted bool) {
 	return evict
 }
 
+// addCold adds a new item at the head of the cold region.  The oldest item
+// is evicted afterwards, so that the cold region is sized for the final
+// length of the cache.  The new item cannot be its own victim: the cold
+// region it enters only ends with it if the cache was empty, and then only
+// tombstones can need evicting.
+func (c *LRU) addCold(key, value interface{}) (evicted bool) {
+	ent := &entry{key: key, value: value, cold: true}
+	var e *list.Element
+	if c.coldHead != nil {
+		e = c.evictList.InsertBefore(ent, c.coldHead)
+	} else {
+		e = c.evictList.PushBack(ent)
+	}
+	c.items[key] = e
+	c.coldHead = e
+	c.coldLen++
+
+	evict := c.evictList.Len()+c.tombstones.Len() > c.size
+	if evict {
+		c.removeOldest()
+	}
+	c.rebalanceCold()
+	return evict
+}
+
+// promote moves an accessed element to the front, out of the cold region.
+func (c *LRU) promote(e *list.Element) {
+	c.leaveCold(e)
+	c.evictList.MoveToFront(e)
+	c.rebalanceCold()
+}
+
+// leaveCold takes an element out of the cold region before it is moved or
+// removed from the evictList.
+func (c *LRU) leaveCold(e *list.Element) {
+	kv := e.Value.(*entry)
+	if !kv.cold {
+		return
+	}
+	if c.coldHead == e {
+		c.coldHead = e.Next()
+	}
+	kv.cold = false
+	c.coldLen--
+}
+
+// coldTarget returns the length of the cold region: coldRatio of the cache
+// rounded up, but at least two entries, so that the entry at its head,
+// usually the newest one, is not the next to be evicted.
+func (c *LRU) coldTarget() int {
+	if c.coldRatio == 0 {
+		return 0
+	}
+	n := c.evictList.Len()
+	target := int(math.Ceil(c.coldRatio * float64(n)))
+	if target < 2 {
+		target = 2
+	}
+	if target > n {
+		target = n
+	}
+	return target
+}
+
+// rebalanceCold moves the head of the cold region until it spans coldRatio
+// of the cache.
+func (c *LRU) rebalanceCold() {
+	target := c.coldTarget()
+	for c.coldLen < target {
+		e := c.evictList.Back()
+		if c.coldHead != nil {
+			e = c.coldHead.Prev()
+		}
+		if e == nil {
+			break
+		}
+		e.Value.(*entry).cold = true
+		c.coldHead = e
+		c.coldLen++
+	}
+	for c.coldLen > target {
+		c.coldHead.Value.(*entry).cold = false
+		c.coldHead = c.coldHead.Next()
+		c.coldLen--
+	}
+}
+
 // Get looks up a key's value from the cache.
 func (c *LRU) Get(key interface{}) (value interface{}, ok bool) {
 	if ent, ok := c.items[key]; ok {
-		c.evictList.MoveToFront(ent)
+		c.promote(ent)
 		if ent.Value.(*entry) == nil {
 			return nil, false
 		}
@@ -99,15 +289,29 @@ func (c *LRU) Peek(key interface{}) (value interface{}, ok bool) {
 }
 
 // Remove removes the provided key from the cache, returning if the
-// key was contained.
+// key was contained.  See SetDeferredRemoval for batching the callback.
 func (c *LRU) Remove(key interface{}) (present bool) {
 	if ent, ok := c.items[key]; ok {
-		c.removeElement(ent)
+		if c.deferRemoval {
+			c.bury(ent)
+		} else {
+			c.removeElement(ent)
+		}
 		return true
 	}
 	return false
 }
 
+// Take removes the provided key from the cache and returns its value,
+// without firing the eviction callback: ownership of the value passes back
+// to the caller.
+func (c *LRU) Take(key interface{}) (value interface{}, ok bool) {
+	if ent, ok := c.items[key]; ok {
+		return c.unlinkElement(ent).value, true
+	}
+	return nil, false
+}
+
 // RemoveOldest removes the oldest item from the cache.
 func (c *LRU) RemoveOldest() (key, value interface{}, ok bool) {
 	ent := c.evictList.Back()
@@ -140,6 +344,34 @@ func (c *LRU) Keys() []interface{} {
 	return keys
 }
 
+// KeysWhere returns the keys of the entries for which pred returns true,
+// from oldest to newest, without updating their "recently used"-ness.
+func (c *LRU) KeysWhere(pred func(key, value interface{}) bool) []interface{} {
+	var keys []interface{}
+	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
+		kv := ent.Value.(*entry)
+		if pred(kv.key, kv.value) {
+			keys = append(keys, kv.key)
+		}
+	}
+	return keys
+}
+
+// Position returns the distance of key from eviction, 0 being the next
+// entry to be evicted and Len()-1 the most recently used one, without
+// updating its "recently used"-ness.  It walks the list from the cold end,
+// so it is cheapest for cold entries.
+func (c *LRU) Position(key interface{}) (pos int, ok bool) {
+	target, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	for ent := c.evictList.Back(); ent != target; ent = ent.Prev() {
+		pos++
+	}
+	return pos, true
+}
+
 // Len returns the number of items in the cache.
 func (c *LRU) Len() int {
 	return c.evictList.Len()
@@ -147,7 +379,8 @@ func (c *LRU) Len() int {
 
 // Resize changes the cache size.
 func (c *LRU) Resize(size int) (evicted int) {
-	diff := c.Len() - size
+	defer c.recordAudit("Resize", "", c.auditState())
+	diff := c.Len() + c.tombstones.Len() - size
 	if diff < 0 {
 		diff = 0
 	}
@@ -158,8 +391,13 @@ func (c *LRU) Resize(size int) (evicted int) {
 	return diff
 }
 
-// removeOldest removes the oldest item from the cache.
+// removeOldest makes room for one entry: it reaps the oldest tombstone if
+// there is one, and removes the oldest item from the cache otherwise.
 func (c *LRU) removeOldest() {
+	if t := c.tombstones.Front(); t != nil {
+		c.reapElement(t)
+		return
+	}
 	ent := c.evictList.Back()
 	if ent != nil {
 		c.removeElement(ent)
@@ -168,10 +406,19 @@ func (c *LRU) removeOldest() {
 
 // removeElement is used to remove a given list element from the cache
 func (c *LRU) removeElement(e *list.Element) {
-	c.evictList.Remove(e)
-	kv := e.Value.(*entry)
-	delete(c.items, kv.key)
+	kv := c.unlinkElement(e)
 	if c.onEvict != nil {
 		c.onEvict(kv.key, kv.value)
 	}
 }
+
+// unlinkElement removes a given list element from the cache without firing
+// the eviction callback.
+func (c *LRU) unlinkElement(e *list.Element) *entry {
+	c.leaveCold(e)
+	c.evictList.Remove(e)
+	kv := e.Value.(*entry)
+	delete(c.items, kv.key)
+	c.rebalanceCold()
+	return kv
+}
@@ -1,8 +1,11 @@
 package simplelru
 
 import (
+	"compress/flate"
 	"container/list"
 	"errors"
+	"math/rand"
+	"time"
 )
 
 // EvictCallback is used to get a callback when a cache entry is evicted
@@ -17,6 +20,45 @@ type LRUWithAccounting struct {
 	items     map[interface{}]*list.Element
 	onEvict   EvictCallback
 	onAccount AccountCallback
+	clock     Clock
+	name      string
+	logger    Logger
+
+	// Compression of large []byte values, see SetCompressionThreshold.
+	compressThreshold int
+	deflater          *flate.Writer
+
+	// Sampled eviction, see SetSampledEviction.
+	sampleSize int
+	rand       *rand.Rand
+
+	audit *auditLog
+
+	// Deferred removal, see SetDeferredRemoval.
+	deferRemoval bool
+	tombstones   *list.List
+	tombstoned   map[interface{}]*list.Element
+}
+
+// accountedEntry is used to hold a value in the evictList of an
+// LRUWithAccounting, along with the weight it was accounted for.
+type accountedEntry struct {
+	key        interface{}
+	value      interface{}
+	weight     int
+	updated    time.Time
+	compressed bool
+}
+
+// Entry describes a cache entry together with its accounting metadata.
+type Entry struct {
+	Key   interface{}
+	Value interface{}
+	// Weight is the size reported by the accounting callback when the
+	// entry was last written.
+	Weight int
+	// Age is the time elapsed since the entry was last written.
+	Age time.Duration
 }
 
 // NewLRU constructs an LRU of the given size
@@ -25,66 +67,306 @@ func NewLRUWithAccounting(limit int, onAccount AccountCallback, onEvict EvictCal
 		return nil, errors.New("must provide a positive size")
 	}
 	c := &LRUWithAccounting{
-		limit:     limit,
-		evictList: list.New(),
-		items:     make(map[interface{}]*list.Element),
-		onEvict:   onEvict,
-		onAccount: onAccount,
+		limit:      limit,
+		evictList:  list.New(),
+		items:      make(map[interface{}]*list.Element),
+		onEvict:    onEvict,
+		onAccount:  onAccount,
+		tombstones: list.New(),
+		tombstoned: make(map[interface{}]*list.Element),
+		clock:      systemClock{},
 	}
 	return c, nil
 }
 
+// SetClock sets the clock used to compute entry ages.  A nil clock restores
+// the system clock.
+func (c *LRUWithAccounting) SetClock(clock Clock) {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	c.clock = clock
+}
+
+// Clock returns the clock used to compute entry ages.
+func (c *LRUWithAccounting) Clock() Clock {
+	return c.clock
+}
+
+// SetName names the cache in the events sent to its Logger.
+func (c *LRUWithAccounting) SetName(name string) {
+	c.name = name
+}
+
+// Name returns the name of the cache.
+func (c *LRUWithAccounting) Name() string {
+	return c.name
+}
+
+// SetLogger sets the Logger receiving events about unusual conditions.  A
+// nil logger disables logging.
+func (c *LRUWithAccounting) SetLogger(logger Logger) {
+	c.logger = logger
+}
+
+// Logger returns the Logger of the cache, or nil.
+func (c *LRUWithAccounting) Logger() Logger {
+	return c.logger
+}
+
+// log sends an event to the Logger, if any.
+func (c *LRUWithAccounting) log(event string, keyvals ...interface{}) {
+	if c.logger != nil {
+		c.logger.Log(c.name, event, keyvals...)
+	}
+}
+
 // Purge is used to completely clear the cache.
 func (c *LRUWithAccounting) Purge() {
+	defer c.recordAudit("Purge", "", c.auditState())
 	for k, v := range c.items {
 		if c.onEvict != nil {
-			c.onEvict(k, v.Value.(*entry).value)
+			c.onEvict(k, c.valueOf(v.Value.(*accountedEntry)))
 		}
 		delete(c.items, k)
 	}
 	c.evictList.Init()
+	c.Reap()
 	c.size = 0
 }
 
+// SetDeferredRemoval controls whether Remove defers the eviction callback.
+// When enabled, a removed entry immediately disappears from the cache, but
+// is kept as a tombstone and only reported to the eviction callback by the
+// next call to Reap, so that external indexes can be synchronized in
+// batches.  Tombstones stay accounted for in AccountingSize until they are
+// reaped, and are reaped inline, oldest first, before any entry is evicted
+// to make room.  Adding a tombstoned key again reaps its tombstone inline
+// first, so the callback never reports a key that is back in the cache.
+// Capacity evictions still fire the callback inline.
+func (c *LRUWithAccounting) SetDeferredRemoval(enabled bool) {
+	defer c.recordAudit("SetDeferredRemoval", auditDetail("enabled", enabled), c.auditState())
+	c.deferRemoval = enabled
+}
+
+// Reap fires the eviction callback for every entry removed since the last
+// call, in removal order, and drops the tombstones.  Returns the number of
+// entries reaped.
+func (c *LRUWithAccounting) Reap() (reaped int) {
+	for c.tombstones.Len() > 0 {
+		c.reapElement(c.tombstones.Front())
+		reaped++
+	}
+	return reaped
+}
+
+// Tombstones returns the number of removed entries waiting for Reap.
+func (c *LRUWithAccounting) Tombstones() int {
+	return c.tombstones.Len()
+}
+
+// bury moves a removed element to the tombstones, keeping its weight
+// accounted for.
+func (c *LRUWithAccounting) bury(e *list.Element) {
+	kv := c.detachElement(e)
+	c.tombstoned[kv.key] = c.tombstones.PushBack(kv)
+}
+
+// reapElement drops a tombstone and fires the eviction callback for it.
+func (c *LRUWithAccounting) reapElement(t *list.Element) {
+	kv := c.tombstones.Remove(t).(*accountedEntry)
+	delete(c.tombstoned, kv.key)
+	c.release(kv)
+	if c.onEvict != nil {
+		c.onEvict(kv.key, c.valueOf(kv))
+	}
+}
+
 // Add adds a value to the cache.  Returns true if an eviction occurred.
 func (c *LRUWithAccounting) Add(key, value interface{}) (evicted bool) {
+	c.insert(key, value)
+	return c.evictIfNeeded()
+}
+
+// AddNoEvict adds a value to the cache without evicting anything, even if
+// the accounting size exceeds the limit afterwards.  Returns true if the
+// cache is over its limit, in which case the caller is expected to call
+// EvictTo to bring it back down.
+func (c *LRUWithAccounting) AddNoEvict(key, value interface{}) (overLimit bool) {
+	c.insert(key, value)
+	return c.size > c.limit
+}
+
+// AddBounded adds a value to the cache, evicting at most budget of the
+// oldest entries.  Any remaining overshoot is trimmed by subsequent calls to
+// Add, AddBounded or EvictTo.  Returns whether an eviction occurred and
+// whether the cache is still over its limit.
+func (c *LRUWithAccounting) AddBounded(key, value interface{}, budget int) (evicted, overLimit bool) {
+	c.insert(key, value)
+	n := c.evictTo(c.limit, budget)
+	c.checkEvictionStorm(n)
+	return n > 0, c.size > c.limit
+}
+
+// insert adds or updates an entry and moves it to the front, without
+// enforcing the limit.
+func (c *LRUWithAccounting) insert(key, value interface{}) {
+	stored, compressed := c.compress(value)
+	c.insertWeighted(key, stored, compressed, c.onAccount(key, stored))
+}
+
+// insertWeighted is like insert, but takes the value as stored and accounts
+// it for the given weight instead of calling the accounting callback.
+func (c *LRUWithAccounting) insertWeighted(key, value interface{}, compressed bool, weight int) {
+	if weight > c.limit {
+		c.log(EventOversizedEntry, "key", key, "weight", weight, "limit", c.limit)
+	}
+	if t, ok := c.tombstoned[key]; ok {
+		c.reapElement(t)
+	}
+
 	// Check for existing item
 	if ent, ok := c.items[key]; ok {
 		c.evictList.MoveToFront(ent)
-		c.size -= c.onAccount(ent.Value.(*entry).key, ent.Value.(*entry).value)
-		ent.Value.(*entry).value = value
-		c.size += c.onAccount(ent.Value.(*entry).key, ent.Value.(*entry).value)
-
-		return c.evictIfNeeded()
+		kv := ent.Value.(*accountedEntry)
+		c.size += weight - kv.weight
+		kv.value = value
+		kv.compressed = compressed
+		kv.weight = weight
+		kv.updated = c.clock.Now()
+		return
 	}
 
 	// Add new item
-	ent := &entry{key, value}
+	ent := &accountedEntry{key, value, weight, c.clock.Now(), compressed}
 	entry := c.evictList.PushFront(ent)
 	c.items[key] = entry
-	c.size += c.onAccount(key, value)
-
-	return c.evictIfNeeded()
+	c.size += weight
 }
 
 func (c *LRUWithAccounting) evictIfNeeded() (evicted bool) {
-	evict := c.size > c.limit
+	return c.EvictTo(c.limit) > 0
+}
+
+// EvictTo removes the oldest entries until the accounting size is at most
+// target.  Returns the number of entries evicted.
+func (c *LRUWithAccounting) EvictTo(target int) (evicted int) {
+	evicted = c.evictTo(target, -1)
+	c.checkEvictionStorm(evicted)
+	return evicted
+}
 
-	for c.size > c.limit {
-		c.removeOldest()
+// EvictToBounded is like EvictTo, but evicts at most budget entries, so that
+// a long eviction chain can be split into short passes.  It does not log
+// EventEvictionStorm, since a single pass is only part of the chain; the
+// caller is expected to report the total instead.
+func (c *LRUWithAccounting) EvictToBounded(target, budget int) (evicted int) {
+	return c.evictTo(target, budget)
+}
+
+// evictTo evicts until the accounting size is at most target, or budget
+// entries were evicted.  A negative budget is unlimited.
+func (c *LRUWithAccounting) evictTo(target, budget int) (evicted int) {
+	for c.size > target && c.tombstones.Len() > 0 && evicted != budget {
+		c.reapElement(c.tombstones.Front())
+		evicted++
+	}
+	if c.sampleSize > 0 {
+		if budget >= 0 {
+			budget -= evicted
+		}
+		evicted += c.evictSampled(target, budget)
+	} else {
+		for c.size > target && c.evictList.Len() > 0 && evicted != budget {
+			c.removeOldest()
+			evicted++
+		}
 	}
+	return evicted
+}
 
-	return evict
+// SetSampledEviction changes how Add and EvictTo reclaim space: instead of
+// repeatedly removing the single oldest entry, they remove entries drawn at
+// random from the coldest sampleSize ones, heavier entries being more
+// likely to be picked, so that large amounts of space are reclaimed with
+// fewer evictions.  The newest entry is never part of the sample, so an
+// entry is not evicted by the Add that inserted it unless it is the only
+// one left.  A sampleSize of 0 restores strict LRU eviction.
+func (c *LRUWithAccounting) SetSampledEviction(sampleSize int) error {
+	if sampleSize < 0 {
+		return errors.New("invalid sample size")
+	}
+	defer c.recordAudit("SetSampledEviction", auditDetail("sampleSize", sampleSize), c.auditState())
+	c.sampleSize = sampleSize
+	if c.rand == nil {
+		c.rand = rand.New(rand.NewSource(c.clock.Now().UnixNano()))
+	}
+	return nil
+}
+
+// evictSampled is the sampled variant of evictTo.
+func (c *LRUWithAccounting) evictSampled(target, budget int) (evicted int) {
+	sample := make([]*list.Element, 0, c.sampleSize)
+	for c.size > target && c.evictList.Len() > 0 && evicted != budget {
+		// Take the coldest entries in one pass, leaving out the newest.
+		sample = sample[:0]
+		total := 0
+		front := c.evictList.Front()
+		for e := c.evictList.Back(); e != front && len(sample) < c.sampleSize; e = e.Prev() {
+			sample = append(sample, e)
+			total += sampleWeight(e)
+		}
+		if len(sample) == 0 {
+			c.removeOldest()
+			evicted++
+			continue
+		}
+
+		for c.size > target && len(sample) > 0 && evicted != budget {
+			r := c.rand.Intn(total)
+			i := 0
+			for ; r >= sampleWeight(sample[i]); i++ {
+				r -= sampleWeight(sample[i])
+			}
+			total -= sampleWeight(sample[i])
+			c.removeElement(sample[i])
+			sample[i] = sample[len(sample)-1]
+			sample = sample[:len(sample)-1]
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// sampleWeight is the weight of an element when drawing eviction victims;
+// every entry has a chance to be picked.
+func sampleWeight(e *list.Element) int {
+	if w := e.Value.(*accountedEntry).weight; w > 1 {
+		return w
+	}
+	return 1
+}
+
+// checkEvictionStorm logs an event if a single call evicted a lot.
+func (c *LRUWithAccounting) checkEvictionStorm(evicted int) {
+	if evicted >= EvictionStormThreshold {
+		c.log(EventEvictionStorm, "evicted", evicted, "size", c.size, "limit", c.limit)
+	}
+}
+
+// Limit returns the maximum accounting size of the cache.
+func (c *LRUWithAccounting) Limit() int {
+	return c.limit
 }
 
 // Get looks up a key's value from the cache.
 func (c *LRUWithAccounting) Get(key interface{}) (value interface{}, ok bool) {
 	if ent, ok := c.items[key]; ok {
 		c.evictList.MoveToFront(ent)
-		if ent.Value.(*entry) == nil {
+		if ent.Value.(*accountedEntry) == nil {
 			return nil, false
 		}
-		return ent.Value.(*entry).value, true
+		return c.valueOf(ent.Value.(*accountedEntry)), true
 	}
 	return
 }
@@ -101,28 +383,42 @@ func (c *LRUWithAccounting) Contains(key interface{}) (ok bool) {
 func (c *LRUWithAccounting) Peek(key interface{}) (value interface{}, ok bool) {
 	var ent *list.Element
 	if ent, ok = c.items[key]; ok {
-		return ent.Value.(*entry).value, true
+		return c.valueOf(ent.Value.(*accountedEntry)), true
 	}
 	return nil, ok
 }
 
 // Remove removes the provided key from the cache, returning if the
-// key was contained.
+// key was contained.  See SetDeferredRemoval for batching the callback.
 func (c *LRUWithAccounting) Remove(key interface{}) (present bool) {
 	if ent, ok := c.items[key]; ok {
-		c.removeElement(ent)
+		if c.deferRemoval {
+			c.bury(ent)
+		} else {
+			c.removeElement(ent)
+		}
 		return true
 	}
 	return false
 }
 
+// Take removes the provided key from the cache and returns its value,
+// without firing the eviction callback: ownership of the value passes back
+// to the caller.
+func (c *LRUWithAccounting) Take(key interface{}) (value interface{}, ok bool) {
+	if ent, ok := c.items[key]; ok {
+		return c.valueOf(c.unlinkElement(ent)), true
+	}
+	return nil, false
+}
+
 // RemoveOldest removes the oldest item from the cache.
 func (c *LRUWithAccounting) RemoveOldest() (key, value interface{}, ok bool) {
 	ent := c.evictList.Back()
 	if ent != nil {
 		c.removeElement(ent)
-		kv := ent.Value.(*entry)
-		return kv.key, kv.value, true
+		kv := ent.Value.(*accountedEntry)
+		return kv.key, c.valueOf(kv), true
 	}
 	return nil, nil, false
 }
@@ -131,39 +427,127 @@ func (c *LRUWithAccounting) RemoveOldest() (key, value interface{}, ok bool) {
 func (c *LRUWithAccounting) GetOldest() (key, value interface{}, ok bool) {
 	ent := c.evictList.Back()
 	if ent != nil {
-		kv := ent.Value.(*entry)
-		return kv.key, kv.value, true
+		kv := ent.Value.(*accountedEntry)
+		return kv.key, c.valueOf(kv), true
 	}
 	return nil, nil, false
 }
 
+// GetNewest returns the newest entry
+func (c *LRUWithAccounting) GetNewest() (key, value interface{}, ok bool) {
+	ent := c.evictList.Front()
+	if ent != nil {
+		kv := ent.Value.(*accountedEntry)
+		return kv.key, c.valueOf(kv), true
+	}
+	return nil, nil, false
+}
+
+// GetOldestEntry returns the oldest entry along with its accounted weight
+// and age, without updating its "recently used"-ness.
+func (c *LRUWithAccounting) GetOldestEntry() (Entry, bool) {
+	return c.describe(c.evictList.Back())
+}
+
+// GetNewestEntry returns the newest entry along with its accounted weight
+// and age, without updating its "recently used"-ness.
+func (c *LRUWithAccounting) GetNewestEntry() (Entry, bool) {
+	return c.describe(c.evictList.Front())
+}
+
+// PeekNewestWithin returns the most recently used entries, from newest to
+// oldest, whose cumulative accounted weight fits within budget.  It stops at
+// the first entry that would exceed the budget, so the result is always the
+// hottest prefix of the cache.  Recent-ness is not updated.
+func (c *LRUWithAccounting) PeekNewestWithin(budget int) []Entry {
+	var entries []Entry
+	used := 0
+	for e := c.evictList.Front(); e != nil; e = e.Next() {
+		ent, _ := c.describe(e)
+		if used+ent.Weight > budget {
+			break
+		}
+		used += ent.Weight
+		entries = append(entries, ent)
+	}
+	return entries
+}
+
+// describe builds the Entry for a list element, if any.
+func (c *LRUWithAccounting) describe(e *list.Element) (Entry, bool) {
+	if e == nil {
+		return Entry{}, false
+	}
+	kv := e.Value.(*accountedEntry)
+	return Entry{
+		Key:    kv.key,
+		Value:  c.valueOf(kv),
+		Weight: kv.weight,
+		Age:    c.clock.Since(kv.updated),
+	}, true
+}
+
 // Keys returns a slice of the keys in the cache, from oldest to newest.
 func (c *LRUWithAccounting) Keys() []interface{} {
 	keys := make([]interface{}, len(c.items))
 	i := 0
 	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
-		keys[i] = ent.Value.(*entry).key
+		keys[i] = ent.Value.(*accountedEntry).key
 		i++
 	}
 	return keys
 }
 
+// KeysWhere returns the keys of the entries for which pred returns true,
+// from oldest to newest, without updating their "recently used"-ness.
+func (c *LRUWithAccounting) KeysWhere(pred func(key, value interface{}) bool) []interface{} {
+	var keys []interface{}
+	for ent := c.evictList.Back(); ent != nil; ent = ent.Prev() {
+		kv := ent.Value.(*accountedEntry)
+		if pred(kv.key, c.valueOf(kv)) {
+			keys = append(keys, kv.key)
+		}
+	}
+	return keys
+}
+
+// Position returns the distance of key from eviction, 0 being the next
+// entry to be evicted and Len()-1 the most recently used one, without
+// updating its "recently used"-ness.  It walks the list from the cold end,
+// so it is cheapest for cold entries.
+func (c *LRUWithAccounting) Position(key interface{}) (pos int, ok bool) {
+	target, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	for ent := c.evictList.Back(); ent != target; ent = ent.Prev() {
+		pos++
+	}
+	return pos, true
+}
+
 // Len returns the number of items in the cache.
 func (c *LRUWithAccounting) Len() int {
 	return c.evictList.Len()
 }
 
-// Resize changes the cache size.
+// Resize changes the cache limit, evicting the oldest entries until the
+// accounting size fits within it.  Like the limit given to
+// NewLRUWithAccounting, size is measured by the accounting callback, not in
+// entries.  Returns the number of entries evicted.
 func (c *LRUWithAccounting) Resize(size int) (evicted int) {
-	diff := c.Len() - size
-	if diff < 0 {
-		diff = 0
-	}
-	for i := 0; i < diff; i++ {
-		c.removeOldest()
-	}
+	defer c.recordAudit("Resize", "", c.auditState())
+	c.limit = size
+	return c.EvictTo(size)
+}
+
+// ResizeNoEvict changes the cache limit without evicting anything.  Returns
+// true if the cache is over its new limit, in which case the caller is
+// expected to call EvictTo to bring it back down.
+func (c *LRUWithAccounting) ResizeNoEvict(size int) (overLimit bool) {
+	defer c.recordAudit("Resize", "", c.auditState())
 	c.limit = size
-	return diff
+	return c.size > c.limit
 }
 
 // removeOldest removes the oldest item from the cache.
@@ -181,11 +565,35 @@ func (c *LRUWithAccounting) AccountingSize() int {
 
 // removeElement is used to remove a given list element from the cache
 func (c *LRUWithAccounting) removeElement(e *list.Element) {
+	kv := c.unlinkElement(e)
+	if c.onEvict != nil {
+		c.onEvict(kv.key, c.valueOf(kv))
+	}
+}
+
+// unlinkElement removes a given list element from the cache without firing
+// the eviction callback.
+func (c *LRUWithAccounting) unlinkElement(e *list.Element) *accountedEntry {
+	kv := c.detachElement(e)
+	c.release(kv)
+	return kv
+}
+
+// detachElement removes a given list element from the cache, leaving its
+// weight accounted for.
+func (c *LRUWithAccounting) detachElement(e *list.Element) *accountedEntry {
 	c.evictList.Remove(e)
-	kv := e.Value.(*entry)
+	kv := e.Value.(*accountedEntry)
 	delete(c.items, kv.key)
-	if c.onEvict != nil {
-		c.onEvict(kv.key, kv.value)
+	return kv
+}
+
+// release stops accounting for a removed entry.
+func (c *LRUWithAccounting) release(kv *accountedEntry) {
+	c.size -= kv.weight
+	if c.logger != nil {
+		if weight := c.onAccount(kv.key, kv.value); weight != kv.weight {
+			c.log(EventAccountingDrift, "key", kv.key, "accounted", kv.weight, "weight", weight)
+		}
 	}
-	c.size -= c.onAccount(kv.key, kv.value)
 }
@@ -2,6 +2,7 @@ package simplelru
 
 import (
 	"fmt"
+	"math/rand"
 	"testing"
 
 	"gotest.tools/assert"
@@ -113,3 +114,269 @@ func TestLRUWithAccounting_update(t *testing.T) {
 
 	assert.Equal(t, evictCounter, 14)
 }
+
+func TestLRUWithAccounting_AddBounded(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		evictCounter++
+	}
+	onAccount := func(k interface{}, v interface{}) int {
+		return len(v.([]byte))
+	}
+	l, err := NewLRUWithAccounting(10, onAccount, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		l.Add(i, []byte{0})
+	}
+
+	// Needs 5 evictions, but only 2 are allowed.
+	evicted, overLimit := l.AddBounded(10, make([]byte, 5), 2)
+	assert.Equal(t, evicted, true)
+	assert.Equal(t, overLimit, true)
+	assert.Equal(t, evictCounter, 2)
+	assert.Equal(t, l.AccountingSize(), 13)
+
+	// A zero budget never evicts.
+	evicted, overLimit = l.AddBounded(11, []byte{0}, 0)
+	assert.Equal(t, evicted, false)
+	assert.Equal(t, overLimit, true)
+	assert.Equal(t, evictCounter, 2)
+
+	// Subsequent calls keep trimming the overshoot.
+	evicted, overLimit = l.AddBounded(12, []byte{0}, 10)
+	assert.Equal(t, evicted, true)
+	assert.Equal(t, overLimit, false)
+	assert.Equal(t, l.AccountingSize(), 10)
+	assert.Equal(t, evictCounter, 7)
+
+	// EvictToBounded splits an eviction chain into passes.
+	assert.Equal(t, l.EvictToBounded(4, 3), 3)
+	assert.Equal(t, l.AccountingSize(), 7)
+	assert.Equal(t, l.EvictToBounded(4, 3), 1)
+	assert.Equal(t, l.EvictToBounded(4, 3), 0)
+	assert.Equal(t, l.AccountingSize(), 2)
+
+	// Resize evicts by accounting size.
+	l.Add(13, make([]byte, 5))
+	assert.Equal(t, l.Resize(5), 2)
+	assert.Equal(t, l.AccountingSize(), 5)
+	assert.Equal(t, l.ResizeNoEvict(4), true)
+	assert.Equal(t, l.Len(), 1)
+}
+
+func TestLRUWithAccounting_OldestNewestEntry(t *testing.T) {
+	onAccount := func(k interface{}, v interface{}) int {
+		return len(v.([]byte))
+	}
+	l, err := NewLRUWithAccounting(10, onAccount, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if _, ok := l.GetOldestEntry(); ok {
+		t.Fatalf("should be empty")
+	}
+	if _, _, ok := l.GetNewest(); ok {
+		t.Fatalf("should be empty")
+	}
+
+	l.Add(1, make([]byte, 3))
+	l.Add(2, make([]byte, 1))
+	l.Add(3, make([]byte, 2))
+
+	oldest, ok := l.GetOldestEntry()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, oldest.Key, 1)
+	assert.Equal(t, oldest.Weight, 3)
+	if oldest.Age < 0 {
+		t.Fatalf("bad age: %v", oldest.Age)
+	}
+
+	newest, ok := l.GetNewestEntry()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, newest.Key, 3)
+	assert.Equal(t, newest.Weight, 2)
+
+	k, _, ok := l.GetNewest()
+	assert.Equal(t, ok, true)
+	assert.Equal(t, k, 3)
+
+	// Updating an entry refreshes its weight.
+	l.Add(1, make([]byte, 5))
+	newest, _ = l.GetNewestEntry()
+	assert.Equal(t, newest.Key, 1)
+	assert.Equal(t, newest.Weight, 5)
+	assert.Equal(t, l.AccountingSize(), 8)
+}
+
+func TestLRUWithAccounting_PeekNewestWithin(t *testing.T) {
+	onAccount := func(k interface{}, v interface{}) int {
+		return len(v.([]byte))
+	}
+	l, err := NewLRUWithAccounting(100, onAccount, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, make([]byte, 4))
+	l.Add(2, make([]byte, 3))
+	l.Add(3, make([]byte, 2))
+	l.Add(4, make([]byte, 1))
+
+	entries := l.PeekNewestWithin(6)
+	assert.Equal(t, len(entries), 3)
+	for i, ent := range entries {
+		assert.Equal(t, ent.Key, 4-i)
+		assert.Equal(t, ent.Weight, i+1)
+	}
+
+	// Recent-ness is untouched.
+	k, _, _ := l.GetOldest()
+	assert.Equal(t, k, 1)
+
+	assert.Equal(t, len(l.PeekNewestWithin(0)), 0)
+	assert.Equal(t, len(l.PeekNewestWithin(100)), 4)
+}
+
+func TestLRUWithAccounting_Take(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		evictCounter++
+	}
+	onAccount := func(k interface{}, v interface{}) int {
+		return len(v.([]byte))
+	}
+	l, err := NewLRUWithAccounting(10, onAccount, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, make([]byte, 3))
+	l.Add(2, make([]byte, 4))
+	v, ok := l.Take(2)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, len(v.([]byte)), 4)
+	assert.Equal(t, evictCounter, 0)
+	assert.Equal(t, l.AccountingSize(), 3)
+	assert.Equal(t, l.Contains(2), false)
+}
+
+func TestLRUWithAccounting_DeferredRemoval(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		evictCounter++
+	}
+	l, err := NewLRUWithAccounting(10, func(k, v interface{}) int { return 2 }, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetDeferredRemoval(true)
+
+	l.Add(1, 1)
+	l.Add(2, 2)
+	l.Remove(1)
+	assert.Equal(t, evictCounter, 0)
+	assert.Equal(t, l.AccountingSize(), 4)
+	assert.Equal(t, l.Tombstones(), 1)
+
+	assert.Equal(t, l.Reap(), 1)
+	assert.Equal(t, evictCounter, 1)
+	assert.Equal(t, l.AccountingSize(), 2)
+
+	// Tombstones are reaped before live entries are evicted.
+	l.Remove(2)
+	for i := 3; i < 8; i++ {
+		l.Add(i, i)
+	}
+	assert.Equal(t, evictCounter, 2)
+	assert.Equal(t, l.Tombstones(), 0)
+	assert.Equal(t, l.Len(), 5)
+
+	// Adding a tombstoned key again reaps its tombstone first.
+	l.Remove(3)
+	l.Add(3, 3)
+	assert.Equal(t, evictCounter, 3)
+	assert.Equal(t, l.Tombstones(), 0)
+	assert.Equal(t, l.AccountingSize(), 10)
+
+	l.Remove(4)
+	l.Purge()
+	assert.Equal(t, evictCounter, 8)
+	assert.Equal(t, l.AccountingSize(), 0)
+}
+
+func TestLRUWithAccounting_SetSampledEviction(t *testing.T) {
+	weights := map[interface{}]int{}
+	var evicted []interface{}
+	onEvicted := func(k interface{}, v interface{}) {
+		evicted = append(evicted, k)
+	}
+	onAccount := func(k interface{}, v interface{}) int {
+		return weights[k]
+	}
+	l, err := NewLRUWithAccounting(100, onAccount, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := l.SetSampledEviction(-1); err == nil {
+		t.Fatalf("should reject a negative sample size")
+	}
+	if err := l.SetSampledEviction(8); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.rand = rand.New(rand.NewSource(1))
+
+	// The coldest 8 entries hold one heavy entry among light ones, so
+	// strict LRU would need 7 evictions to make room for 30 more.
+	for i := 0; i < 40; i++ {
+		weights[i] = 1
+		if i == 6 {
+			weights[i] = 40
+		}
+		l.Add(i, i)
+	}
+	assert.Equal(t, l.AccountingSize(), 79)
+
+	weights["new"] = 30
+	l.Add("new", "new")
+	assert.Assert(t, l.AccountingSize() <= 100)
+	for _, k := range evicted {
+		if k.(int) < 0 || k.(int) > 7 {
+			t.Fatalf("victim %v is not among the coldest entries", k)
+		}
+	}
+	// With this seed the heavy entry is picked right away.
+	assert.DeepEqual(t, evicted, []interface{}{6})
+
+	// Strict eviction again.
+	assert.NilError(t, l.SetSampledEviction(0))
+	evicted = nil
+	keys := l.Keys()
+	weights["big"] = 70
+	l.Add("big", "big")
+	assert.Assert(t, len(evicted) > 0)
+	assert.DeepEqual(t, evicted, keys[:len(evicted)])
+
+	// With fewer entries than the sample size, the entry being added is
+	// still never a victim.
+	l, err = NewLRUWithAccounting(10, onAccount, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.NilError(t, l.SetSampledEviction(8))
+	for seed := int64(0); seed < 50; seed++ {
+		l.Purge()
+		l.rand = rand.New(rand.NewSource(seed))
+		evicted = nil
+		for i := 0; i < 5; i++ {
+			l.Add(i, i)
+		}
+		weights["new"] = 6
+		l.Add("new", "new")
+		assert.Assert(t, l.Contains("new"), "seed %d", seed)
+		assert.Assert(t, l.AccountingSize() <= 10)
+	}
+}
@@ -0,0 +1,64 @@
+package simplelru
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// SetCompressionThreshold makes the cache store []byte values longer than
+// threshold bytes deflated, as long as that makes them smaller.  The
+// accounting callback is then given the compressed bytes, so the accounting
+// size reflects what is actually held, while every other callback and
+// accessor sees the original value.  Reading a compressed entry inflates it
+// again, which costs an allocation each time.  A threshold of 0 disables
+// compression; entries already stored are left as they are.
+func (c *LRUWithAccounting) SetCompressionThreshold(threshold int) {
+	defer c.recordAudit("SetCompressionThreshold", auditDetail("threshold", threshold), c.auditState())
+	c.compressThreshold = threshold
+}
+
+// compress returns the form in which value is stored.
+func (c *LRUWithAccounting) compress(value interface{}) (stored interface{}, compressed bool) {
+	b, ok := value.([]byte)
+	if !ok || c.compressThreshold <= 0 || len(b) <= c.compressThreshold {
+		return value, false
+	}
+
+	var buf bytes.Buffer
+	if c.deflater == nil {
+		// Allocating a flate.Writer is expensive, so it is kept around.
+		c.deflater, _ = flate.NewWriter(&buf, flate.BestSpeed)
+	} else {
+		c.deflater.Reset(&buf)
+	}
+	if _, err := c.deflater.Write(b); err != nil {
+		return value, false
+	}
+	if err := c.deflater.Close(); err != nil {
+		return value, false
+	}
+	if buf.Len() >= len(b) {
+		return value, false
+	}
+	return buf.Bytes(), true
+}
+
+// valueOf returns the original value of an entry.
+func (c *LRUWithAccounting) valueOf(kv *accountedEntry) interface{} {
+	return kv.original()
+}
+
+// original returns the value of the entry as it was added.
+func (kv *accountedEntry) original() interface{} {
+	if !kv.compressed {
+		return kv.value
+	}
+	b, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(kv.value.([]byte))))
+	if err != nil {
+		// The bytes were produced by compress, so this cannot happen
+		// unless they were modified in place.
+		panic("simplelru: corrupt compressed value: " + err.Error())
+	}
+	return b
+}
@@ -0,0 +1,53 @@
+package simplelru
+
+import (
+	"bytes"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestLRUWithAccounting_Compression(t *testing.T) {
+	var evicted [][]byte
+	onEvicted := func(k interface{}, v interface{}) {
+		evicted = append(evicted, v.([]byte))
+	}
+	onAccount := func(k interface{}, v interface{}) int {
+		return len(v.([]byte))
+	}
+	l, err := NewLRUWithAccounting(1000, onAccount, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetCompressionThreshold(64)
+
+	big := bytes.Repeat([]byte("state"), 100)
+	small := []byte("nonce")
+	l.Add("big", big)
+	l.Add("small", small)
+
+	// Only the large value is compressed and accounted as such.
+	if l.AccountingSize() >= len(big)+len(small) {
+		t.Fatalf("big value should be accounted compressed: %v", l.AccountingSize())
+	}
+	ent, _ := l.GetNewestEntry()
+	assert.Equal(t, ent.Weight, len(small))
+
+	v, ok := l.Get("big")
+	assert.Equal(t, ok, true)
+	assert.DeepEqual(t, v, big)
+	v, _ = l.Peek("small")
+	assert.DeepEqual(t, v, small)
+
+	// Incompressible values are kept as is.
+	random := []byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ+/!")
+	l.Add("random", random)
+	ent, _ = l.GetNewestEntry()
+	assert.Equal(t, ent.Weight, len(random))
+
+	// Eviction callbacks see the original value.
+	l.Remove("big")
+	assert.Equal(t, len(evicted), 1)
+	assert.DeepEqual(t, evicted[0], big)
+	assert.Equal(t, l.AccountingSize(), len(small)+len(random))
+}
@@ -0,0 +1,268 @@
+package simplelru
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Layout of the stream written by ExportOrdered:
+//
+//	magic   [4]byte "GLRU"
+//	version byte
+//	records, oldest first, each made of
+//	    tag     byte (recordTag, or compressedRecordTag if the weight is
+//	            that of the compressed value)
+//	    weight  varint
+//	    key     uvarint length + bytes
+//	    value   uvarint length + bytes
+//	endTag  byte
+const (
+	exportMagic   = "GLRU"
+	exportVersion = 1
+
+	recordTag           = 1
+	compressedRecordTag = 2
+	endTag              = 0
+
+	// maxExportFieldLen guards ImportOrdered against allocating absurd
+	// amounts of memory for a corrupted length prefix.
+	maxExportFieldLen = 1 << 30
+)
+
+var (
+	// ErrBadExportFormat is returned by ImportOrdered when the stream is
+	// not a cache export.
+	ErrBadExportFormat = errors.New("simplelru: bad export format")
+
+	// ErrUnsupportedExportVersion is returned by ImportOrdered when the
+	// stream was written by an incompatible version.
+	ErrUnsupportedExportVersion = errors.New("simplelru: unsupported export version")
+)
+
+// MarshalFunc encodes a key and its value for ExportOrdered.
+type MarshalFunc func(key, value interface{}) (k, v []byte, err error)
+
+// UnmarshalFunc decodes a key and its value for ImportOrdered.
+type UnmarshalFunc func(k, v []byte) (key, value interface{}, err error)
+
+// ExportOrdered streams every entry to w, from oldest to newest, along with
+// its accounted weight.  Recent-ness is not updated.
+func (c *LRUWithAccounting) ExportOrdered(w io.Writer, marshal MarshalFunc) error {
+	return c.SnapshotOrdered().Stream(w, marshal)
+}
+
+// OrderedExport is a copy of the entries of a cache, taken by
+// SnapshotOrdered, that can be streamed without holding on to the cache.
+type OrderedExport struct {
+	entries []accountedEntry
+}
+
+// SnapshotOrdered copies every entry, from oldest to newest, along with its
+// accounted weight, so that a concurrent cache can be unlocked before the
+// export is marshalled and written.  Values are shared with the cache, not
+// copied.  Recent-ness is not updated.
+func (c *LRUWithAccounting) SnapshotOrdered() *OrderedExport {
+	entries := make([]accountedEntry, 0, c.evictList.Len())
+	for e := c.evictList.Back(); e != nil; e = e.Prev() {
+		entries = append(entries, *e.Value.(*accountedEntry))
+	}
+	return &OrderedExport{entries: entries}
+}
+
+// Stream writes the snapshot to w in the format read by ImportOrdered.
+func (x *OrderedExport) Stream(w io.Writer, marshal MarshalFunc) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(exportMagic); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(exportVersion); err != nil {
+		return err
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	for i := range x.entries {
+		kv := &x.entries[i]
+		k, v, err := marshal(kv.key, kv.original())
+		if err != nil {
+			return err
+		}
+		tag := byte(recordTag)
+		if kv.compressed {
+			tag = compressedRecordTag
+		}
+		if err := bw.WriteByte(tag); err != nil {
+			return err
+		}
+		if _, err := bw.Write(buf[:binary.PutVarint(buf[:], int64(kv.weight))]); err != nil {
+			return err
+		}
+		for _, field := range [][]byte{k, v} {
+			if _, err := bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(field)))]); err != nil {
+				return err
+			}
+			if _, err := bw.Write(field); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bw.WriteByte(endTag); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportOrdered reads a stream written by ExportOrdered and adds its entries
+// in the same order, so that the exported recency order is reproduced on top
+// of whatever the cache already holds.  Entries keep the weight they were
+// exported with instead of being accounted again, unless only one of the
+// two caches stores them compressed.  If the stream does not fit, the
+// oldest entries are evicted as usual.  Returns the number of entries read.
+//
+// If r is not an io.ByteReader it is buffered, and may be read past the end
+// of the export.
+func (c *LRUWithAccounting) ImportOrdered(r io.Reader, unmarshal UnmarshalFunc) (n int, err error) {
+	x := NewOrderedImport(r, unmarshal)
+	for {
+		batch, done, err := x.Next(importBatchSize)
+		n += c.AddImported(batch)
+		if err != nil || done {
+			return n, err
+		}
+	}
+}
+
+// importBatchSize is the number of records ImportOrdered reads at a time.
+const importBatchSize = 64
+
+// OrderedImport reads a stream written by ExportOrdered in batches, without
+// touching any cache, so that a concurrent cache only needs to be locked
+// while each batch is added with AddImported.
+type OrderedImport struct {
+	r         io.Reader
+	br        io.ByteReader
+	unmarshal UnmarshalFunc
+	started   bool
+	done      bool
+}
+
+// ImportBatch is a run of consecutive records read by OrderedImport.Next.
+type ImportBatch struct {
+	records []importRecord
+}
+
+// importRecord is an entry as read from the stream.
+type importRecord struct {
+	key, value interface{}
+	weight     int
+	compressed bool
+}
+
+// NewOrderedImport prepares to read the stream r.  If r is not an
+// io.ByteReader it is buffered, and may be read past the end of the export.
+func NewOrderedImport(r io.Reader, unmarshal UnmarshalFunc) *OrderedImport {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		b := bufio.NewReader(r)
+		r, br = b, b
+	}
+	return &OrderedImport{r: r, br: br, unmarshal: unmarshal}
+}
+
+// Next reads and unmarshals up to max records.  done is true once the end of
+// the export has been reached.  On error, the batch holds the records read
+// before it.
+func (x *OrderedImport) Next(max int) (batch *ImportBatch, done bool, err error) {
+	batch = &ImportBatch{}
+	if x.done {
+		return batch, true, nil
+	}
+	if !x.started {
+		var header [len(exportMagic) + 1]byte
+		if _, err := io.ReadFull(x.r, header[:]); err != nil {
+			return batch, false, err
+		}
+		if string(header[:len(exportMagic)]) != exportMagic {
+			return batch, false, ErrBadExportFormat
+		}
+		if header[len(exportMagic)] != exportVersion {
+			return batch, false, ErrUnsupportedExportVersion
+		}
+		x.started = true
+	}
+
+	for len(batch.records) < max {
+		tag, err := x.br.ReadByte()
+		if err != nil {
+			return batch, false, err
+		}
+		switch tag {
+		case endTag:
+			x.done = true
+			return batch, true, nil
+		case recordTag, compressedRecordTag:
+		default:
+			return batch, false, ErrBadExportFormat
+		}
+
+		weight, err := binary.ReadVarint(x.br)
+		if err != nil {
+			return batch, false, err
+		}
+		// Weights are written as 64-bit, but int may be narrower on 32-bit
+		// targets such as 386, arm or js/wasm.  A negative weight would let
+		// the cache grow past its limit.
+		if weight < 0 || int64(int(weight)) != weight {
+			return batch, false, ErrBadExportFormat
+		}
+		k, err := readExportField(x.r, x.br)
+		if err != nil {
+			return batch, false, err
+		}
+		v, err := readExportField(x.r, x.br)
+		if err != nil {
+			return batch, false, err
+		}
+		key, value, err := x.unmarshal(k, v)
+		if err != nil {
+			return batch, false, err
+		}
+		batch.records = append(batch.records, importRecord{key, value, int(weight), tag == compressedRecordTag})
+	}
+	return batch, false, nil
+}
+
+// AddImported adds the entries of a batch read by OrderedImport.Next, in
+// order, evicting the oldest entries as usual.  Returns the number of
+// entries added.
+func (c *LRUWithAccounting) AddImported(batch *ImportBatch) int {
+	for _, rec := range batch.records {
+		// The exported weight only applies to the value in the form it
+		// was held by the exporter.
+		weight := rec.weight
+		stored, compressed := c.compress(rec.value)
+		if compressed != rec.compressed {
+			weight = c.onAccount(rec.key, stored)
+		}
+		c.insertWeighted(rec.key, stored, compressed, weight)
+		c.evictIfNeeded()
+	}
+	return len(batch.records)
+}
+
+// readExportField reads a length-prefixed byte string.
+func readExportField(r io.Reader, br io.ByteReader) ([]byte, error) {
+	l, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if l > maxExportFieldLen {
+		return nil, ErrBadExportFormat
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,199 @@
+package simplelru
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func marshalIntBytes(key, value interface{}) ([]byte, []byte, error) {
+	return []byte(strconv.Itoa(key.(int))), value.([]byte), nil
+}
+
+func unmarshalIntBytes(k, v []byte) (interface{}, interface{}, error) {
+	key, err := strconv.Atoi(string(k))
+	return key, v, err
+}
+
+func TestLRUWithAccounting_ExportImportOrdered(t *testing.T) {
+	onAccount := func(k interface{}, v interface{}) int {
+		return len(v.([]byte))
+	}
+	src, err := NewLRUWithAccounting(100, onAccount, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		src.Add(i, []byte(fmt.Sprint(i*100)))
+	}
+	src.Get(3) // 3 becomes the newest
+
+	var buf bytes.Buffer
+	if err := src.ExportOrdered(&buf, marshalIntBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Weights travel with the stream; the destination accounting is
+	// deliberately different.
+	dst, err := NewLRUWithAccounting(100, func(k, v interface{}) int { return 1 }, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	n, err := dst.ImportOrdered(&buf, unmarshalIntBytes)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(t, n, 10)
+	assert.DeepEqual(t, dst.Keys(), src.Keys())
+	assert.Equal(t, dst.AccountingSize(), src.AccountingSize())
+	v, _ := dst.Peek(9)
+	assert.DeepEqual(t, v, []byte("900"))
+
+	// Importing into a smaller cache keeps the newest entries.
+	buf.Reset()
+	if err := src.ExportOrdered(&buf, marshalIntBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	small, err := NewLRUWithAccounting(7, onAccount, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := small.ImportOrdered(&buf, unmarshalIntBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.DeepEqual(t, small.Keys(), []interface{}{9, 3})
+}
+
+func TestLRUWithAccounting_ImportOrderedBadStream(t *testing.T) {
+	l, err := NewLRUWithAccounting(10, func(k, v interface{}) int { return 1 }, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	_, err = l.ImportOrdered(bytes.NewReader([]byte("NOPE\x01\x00")), unmarshalIntBytes)
+	assert.Equal(t, err, ErrBadExportFormat)
+
+	_, err = l.ImportOrdered(bytes.NewReader([]byte("GLRU\x02\x00")), unmarshalIntBytes)
+	assert.Equal(t, err, ErrUnsupportedExportVersion)
+
+	_, err = l.ImportOrdered(bytes.NewReader([]byte("GLRU\x01\x07")), unmarshalIntBytes)
+	assert.Equal(t, err, ErrBadExportFormat)
+	assert.Equal(t, l.Len(), 0)
+
+	// varint(-2) as the weight.
+	n, err := l.ImportOrdered(bytes.NewReader([]byte("GLRU\x01\x01\x03\x011\x01x\x00")), unmarshalIntBytes)
+	assert.Equal(t, err, ErrBadExportFormat)
+	assert.Equal(t, n, 0)
+	assert.Equal(t, l.AccountingSize(), 0)
+}
+
+func TestLRUWithAccounting_ImportOrderedWideWeight(t *testing.T) {
+	// A weight of 1<<40 only fits in a 64-bit int.
+	stream := []byte("GLRU\x01\x01")
+	stream = append(stream, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40) // varint(1<<40)
+	stream = append(stream, 1, '1', 1, 'x', 0)
+
+	l, err := NewLRUWithAccounting(math.MaxInt32, func(k, v interface{}) int { return 1 }, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	n, err := l.ImportOrdered(bytes.NewReader(stream), unmarshalIntBytes)
+	if strconv.IntSize == 32 {
+		assert.Equal(t, err, ErrBadExportFormat)
+		assert.Equal(t, n, 0)
+		return
+	}
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+	// Too heavy for the cache, so it is evicted right away.
+	assert.Equal(t, l.Len(), 0)
+}
+
+func TestLRUWithAccounting_ExportImportCompressed(t *testing.T) {
+	onAccount := func(k interface{}, v interface{}) int {
+		return len(v.([]byte))
+	}
+	big := bytes.Repeat([]byte("state"), 1000)
+	newCache := func(threshold int) *LRUWithAccounting {
+		l, err := NewLRUWithAccounting(10000, onAccount, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		l.SetCompressionThreshold(threshold)
+		return l
+	}
+	transfer := func(src, dst *LRUWithAccounting) {
+		var buf bytes.Buffer
+		if err := src.ExportOrdered(&buf, marshalIntBytes); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if _, err := dst.ImportOrdered(&buf, unmarshalIntBytes); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		v, _ := dst.Peek(1)
+		assert.DeepEqual(t, v, big)
+	}
+
+	compressed := newCache(64)
+	compressed.Add(1, big)
+	raw := newCache(0)
+	raw.Add(1, big)
+
+	// The exported weight is kept when both sides store the same form...
+	dst := newCache(128)
+	transfer(compressed, dst)
+	assert.Equal(t, dst.AccountingSize(), compressed.AccountingSize())
+	dst = newCache(0)
+	transfer(raw, dst)
+	assert.Equal(t, dst.AccountingSize(), len(big))
+
+	// ...and accounted again otherwise.
+	dst = newCache(0)
+	transfer(compressed, dst)
+	assert.Equal(t, dst.AccountingSize(), len(big))
+	dst = newCache(64)
+	transfer(raw, dst)
+	assert.Equal(t, dst.AccountingSize(), compressed.AccountingSize())
+}
+
+func TestOrderedImport_Batches(t *testing.T) {
+	onAccount := func(k interface{}, v interface{}) int {
+		return len(v.([]byte))
+	}
+	src, err := NewLRUWithAccounting(100, onAccount, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		src.Add(i, []byte("v"))
+	}
+	var buf bytes.Buffer
+	if err := src.ExportOrdered(&buf, marshalIntBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	dst, err := NewLRUWithAccounting(100, onAccount, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	x := NewOrderedImport(&buf, unmarshalIntBytes)
+	var sizes []int
+	for {
+		batch, done, err := x.Next(3)
+		assert.NilError(t, err)
+		sizes = append(sizes, dst.AddImported(batch))
+		if done {
+			break
+		}
+	}
+	assert.DeepEqual(t, sizes, []int{3, 3, 3, 1})
+	assert.DeepEqual(t, dst.Keys(), src.Keys())
+	batch, done, err := x.Next(3)
+	assert.Equal(t, dst.AddImported(batch), 0)
+	assert.Equal(t, done, true)
+	assert.NilError(t, err)
+}
@@ -204,3 +204,263 @@ func TestLRU_Resize(t *testing.T) {
 		t.Errorf("Cache should have contained 2 elements")
 	}
 }
+
+// Test that KeysWhere filters without updating recent-ness
+func TestLRU_KeysWhere(t *testing.T) {
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i*10)
+	}
+	keys := l.KeysWhere(func(k, v interface{}) bool {
+		return v.(int)%20 == 0
+	})
+	if len(keys) != 2 || keys[0] != 0 || keys[1] != 2 {
+		t.Errorf("bad keys: %v", keys)
+	}
+
+	l.Add(4, 40)
+	if l.Contains(0) {
+		t.Errorf("KeysWhere should not have updated recent-ness of 0")
+	}
+
+	if keys := l.KeysWhere(func(k, v interface{}) bool { return false }); len(keys) != 0 {
+		t.Errorf("bad keys: %v", keys)
+	}
+}
+
+// Test that Position reports the distance from eviction
+func TestLRU_Position(t *testing.T) {
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Add(i, i)
+	}
+	for i := 0; i < 4; i++ {
+		if pos, ok := l.Position(i); !ok || pos != i {
+			t.Errorf("bad position for %v: %v, %v", i, pos, ok)
+		}
+	}
+
+	l.Get(0)
+	if pos, _ := l.Position(0); pos != 3 {
+		t.Errorf("bad position for 0: %v", pos)
+	}
+	if pos, _ := l.Position(1); pos != 0 {
+		t.Errorf("Position should not have updated recent-ness of 1: %v", pos)
+	}
+	if _, ok := l.Position(9); ok {
+		t.Errorf("9 should not be contained")
+	}
+}
+
+// Test that Take removes without firing the eviction callback
+func TestLRU_Take(t *testing.T) {
+	evictCounter := 0
+	onEvicted := func(k interface{}, v interface{}) {
+		evictCounter++
+	}
+	l, err := NewLRU(2, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	l.Add(1, 10)
+	l.Add(2, 20)
+	if v, ok := l.Take(1); !ok || v != 10 {
+		t.Errorf("1 should be taken with 10: %v, %v", v, ok)
+	}
+	if evictCounter != 0 {
+		t.Errorf("onEvicted should not have been called: %v", evictCounter)
+	}
+	if l.Contains(1) || l.Len() != 1 {
+		t.Errorf("1 should have been removed")
+	}
+	if _, ok := l.Take(1); ok {
+		t.Errorf("1 should not be contained")
+	}
+}
+
+// checkCold verifies that the cold region is a contiguous tail of the list
+// starting at coldHead.
+func checkCold(t *testing.T, l *LRU) {
+	t.Helper()
+	n := 0
+	inCold := false
+	for e := l.evictList.Front(); e != nil; e = e.Next() {
+		if e == l.coldHead {
+			inCold = true
+		}
+		if e.Value.(*entry).cold != inCold {
+			t.Fatalf("cold region is not a contiguous tail")
+		}
+		if inCold {
+			n++
+		}
+	}
+	if n != l.coldLen || n != l.coldTarget() {
+		t.Fatalf("bad cold length: %v, tracked %v", n, l.coldLen)
+	}
+}
+
+// Test that midpoint insertion protects reused entries from a scan
+func TestLRU_SetInsertionPoint(t *testing.T) {
+	l, err := NewLRU(8, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := l.SetInsertionPoint(1); err == nil {
+		t.Errorf("should reject a ratio of 1")
+	}
+	if err := l.SetInsertionPoint(0.5); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 8; i++ {
+		l.Add(i, i)
+		checkCold(t, l)
+	}
+	// Reuse makes 0-3 hot.
+	for i := 0; i < 4; i++ {
+		l.Get(i)
+		checkCold(t, l)
+	}
+
+	// A scan only churns the cold half.
+	for i := 100; i < 200; i++ {
+		l.Add(i, i)
+		checkCold(t, l)
+	}
+	for i := 0; i < 4; i++ {
+		if !l.Contains(i) {
+			t.Errorf("hot entry %v should have survived the scan", i)
+		}
+	}
+
+	// A new entry lands ahead of the cold half, not at the front.
+	l.Add(200, 200)
+	if pos, _ := l.Position(200); pos != 3 {
+		t.Errorf("bad insertion position: %v", pos)
+	}
+	l.Get(200)
+	if pos, _ := l.Position(200); pos != 7 {
+		t.Errorf("accessed entry should be promoted to the front: %v", pos)
+	}
+	checkCold(t, l)
+
+	l.Remove(200)
+	checkCold(t, l)
+	l.Resize(3)
+	checkCold(t, l)
+
+	// Back to plain LRU insertion.
+	if err := l.SetInsertionPoint(0); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	checkCold(t, l)
+	l.Add(300, 300)
+	if pos, _ := l.Position(300); pos != 2 {
+		t.Errorf("bad insertion position: %v", pos)
+	}
+}
+
+// Test that a small cold region is rounded up rather than left empty
+func TestLRU_SetInsertionPointSmall(t *testing.T) {
+	l, err := NewLRU(4, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := l.SetInsertionPoint(0.5); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Filling up does not put unaccessed entries ahead of newer ones.
+	l.Add(1, 1)
+	checkCold(t, l)
+	l.Add(2, 2)
+	checkCold(t, l)
+	if k, _, _ := l.GetOldest(); k != 1 {
+		t.Errorf("bad oldest entry: %v", k)
+	}
+
+	// 0.2 of 4 entries still leaves one cold slot, so a new entry is not
+	// the next victim.
+	l.Purge()
+	if err := l.SetInsertionPoint(0.2); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Add(i, i)
+		checkCold(t, l)
+	}
+	if pos, _ := l.Position(4); pos != 1 {
+		t.Errorf("bad insertion position: %v", pos)
+	}
+	l.Add(5, 5)
+	checkCold(t, l)
+	if !l.Contains(4) {
+		t.Errorf("new entry should survive the next insert")
+	}
+}
+
+// Test that deferred removal holds the callback until Reap
+func TestLRU_DeferredRemoval(t *testing.T) {
+	var evicted []interface{}
+	onEvicted := func(k interface{}, v interface{}) {
+		evicted = append(evicted, k)
+	}
+	l, err := NewLRU(3, onEvicted)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	l.SetDeferredRemoval(true)
+
+	for i := 0; i < 3; i++ {
+		l.Add(i, i)
+	}
+	l.Remove(1)
+	l.Remove(0)
+	if len(evicted) != 0 {
+		t.Errorf("onEvicted should have been deferred: %v", evicted)
+	}
+	if l.Contains(1) || l.Len() != 1 || l.Tombstones() != 2 {
+		t.Errorf("removed entries should be invisible")
+	}
+	if _, ok := l.Get(0); ok {
+		t.Errorf("removed entries should be invisible to Get")
+	}
+
+	// Tombstones count towards the size, and are reaped before capacity
+	// evictions, which are not deferred.
+	for i := 3; i < 6; i++ {
+		l.Add(i, i)
+	}
+	if len(evicted) != 3 || evicted[0] != 1 || evicted[1] != 0 || evicted[2] != 2 {
+		t.Errorf("bad evictions: %v", evicted)
+	}
+	if l.Tombstones() != 0 || l.Reap() != 0 {
+		t.Errorf("tombstones should be gone")
+	}
+
+	// Adding a tombstoned key again reaps its tombstone first.
+	l.Remove(3)
+	l.Add(3, 30)
+	if len(evicted) != 4 || evicted[3] != 3 || l.Tombstones() != 0 {
+		t.Errorf("bad evictions: %v", evicted)
+	}
+	if n := l.Reap(); n != 0 {
+		t.Errorf("bad reap count: %v", n)
+	}
+
+	l.Remove(5)
+	l.Purge()
+	if len(evicted) != 7 || l.Tombstones() != 0 {
+		t.Errorf("Purge should reap tombstones: %v", evicted)
+	}
+}